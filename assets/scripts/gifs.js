@@ -19,11 +19,39 @@ $(function() {
     };
 
     setTimeout(updateProgress, 10);
+    countView($video.data("id"));
   });
 
   $(window).resize(moveGifsAround);
+  $(window).on("pagehide", sendViews);
+  setInterval(sendViews, 5000);
 });
 
+var viewed = {};
+var unsentViews = [];
+
+// countView remembers that a gif was played, once per page, so the plays
+// can be sent together every few seconds instead of as they happen.
+function countView(id) {
+  if (id === undefined || viewed[id]) {
+    return;
+  }
+  viewed[id] = true;
+  unsentViews.push(id);
+}
+
+function sendViews() {
+  $.each(unsentViews, function(i, id) {
+    var path = "/api/views/" + id;
+    if (navigator.sendBeacon) {
+      navigator.sendBeacon(path);
+    } else {
+      $.post(path);
+    }
+  });
+  unsentViews = [];
+}
+
 function gutter() {
   return 10;
 }
@@ -0,0 +1,289 @@
+// Package config loads the settings shared by every ancientcitadel command
+// from an optional JSON file, the environment and command line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	Port        string `json:"port"`
+	DatabaseURL string `json:"database_url"`
+	// QueryTimeout is how many seconds any one query can run for.
+	QueryTimeout int `json:"query_timeout"`
+	// BaseURL is where the site is served from, used in links that leave
+	// the site like tweets and the sitemap.
+	BaseURL       string `json:"base_url"`
+	MaxProcs      int    `json:"max_procs"`
+	PageSize      int    `json:"page_size"`
+	AdminPassword string `json:"admin_password"`
+
+	TwitterConsumerKey    string `json:"twitter_consumer_key"`
+	TwitterConsumerSecret string `json:"twitter_consumer_secret"`
+
+	// Transcoders are the hosts of the remote gifs servers.
+	Transcoders      []string `json:"transcoders"`
+	LocalTranscoders int      `json:"local_transcoders"`
+	Workers          int      `json:"workers"`
+	// FFmpeg is the ffmpeg binary workers run, found on $PATH when it's
+	// empty. ffprobe is expected to be alongside it.
+	FFmpeg string `json:"ffmpeg"`
+	// VisibilityTimeout is how many seconds a worker can go without
+	// extending a job before it's handed out again.
+	VisibilityTimeout int `json:"visibility_timeout"`
+
+	// Media is served through the site's /media unless S3BaseURL points
+	// straight at the bucket or a CDN.
+	MediaDirectory    string `json:"media_directory"`
+	ServeMedia        bool   `json:"serve_media"`
+	PersistMedia      bool   `json:"persist_media"`
+	S3Bucket          string `json:"s3_bucket"`
+	S3Endpoint        string `json:"s3_endpoint"`
+	S3Region          string `json:"s3_region"`
+	S3BaseURL         string `json:"s3_base_url"`
+	S3AccessKeyID     string `json:"s3_access_key_id"`
+	S3SecretAccessKey string `json:"s3_secret_access_key"`
+
+	CheckLinks bool `json:"check_links"`
+	// CountAPIImpressions counts every gif the api returns as a view, for
+	// sites where api clients show them straight away.
+	CountAPIImpressions bool `json:"count_api_impressions"`
+	// TrustProxy takes visitors' addresses from X-Forwarded-For, for when
+	// the site is behind a proxy that sets it, like Heroku's router.
+	TrustProxy bool `json:"trust_proxy"`
+}
+
+// Default returns the settings used for anything that isn't configured.
+func Default() *Config {
+	return &Config{
+		Port:         "8080",
+		DatabaseURL:  "host=/var/run/postgresql dbname=ancientcitadel sslmode=disable",
+		QueryTimeout: 30,
+		BaseURL:      "http://ancientcitadel.com",
+		MaxProcs:     4,
+		PageSize:     20,
+		Transcoders: []string{
+			"http://gifs1.ancientcitadel.com",
+			"http://gifs2.ancientcitadel.com",
+			"http://gifs3.ancientcitadel.com",
+			"http://gifs4.ancientcitadel.com",
+			"http://gifs5.ancientcitadel.com",
+		},
+		Workers:           5,
+		VisibilityTimeout: 15 * 60,
+		MediaDirectory:    "./media",
+		S3Endpoint:        "https://s3.amazonaws.com",
+		S3Region:          "us-east-1",
+		CheckLinks:        true,
+	}
+}
+
+// Load parses args with flags, which should already be bound to fields of
+// c, and fills in c from the JSON file named by -config or CONFIG_FILE and
+// then the environment. Flags given on the command line win over the
+// environment, which wins over the file.
+func (c *Config) Load(flags *flag.FlagSet, args []string) error {
+	return c.load(flags, args, os.Getenv)
+}
+
+func (c *Config) load(flags *flag.FlagSet, args []string, getenv func(string) string) error {
+	file := flags.String("config", getenv("CONFIG_FILE"), "a JSON file to read settings from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	given := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return err
+		}
+	}
+	if err := c.loadEnv(getenv); err != nil {
+		return err
+	}
+	for name, value := range given {
+		flags.Set(name, value)
+	}
+
+	return c.Validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("couldn't read %v: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"PORT":                    &c.Port,
+		"DATABASE_URL":            &c.DatabaseURL,
+		"BASE_URL":                &c.BaseURL,
+		"ADMIN_PASSWORD":          &c.AdminPassword,
+		"TWITTER_CONSUMER_KEY":    &c.TwitterConsumerKey,
+		"TWITTER_CONSUMER_SECRET": &c.TwitterConsumerSecret,
+		"FFMPEG":                  &c.FFmpeg,
+		"MEDIA_DIRECTORY":         &c.MediaDirectory,
+		"S3_BUCKET":               &c.S3Bucket,
+		"S3_ENDPOINT":             &c.S3Endpoint,
+		"S3_REGION":               &c.S3Region,
+		"S3_BASE_URL":             &c.S3BaseURL,
+		"S3_ACCESS_KEY_ID":        &c.S3AccessKeyID,
+		"S3_SECRET_ACCESS_KEY":    &c.S3SecretAccessKey,
+	}
+	for name, field := range strs {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"QUERY_TIMEOUT":      &c.QueryTimeout,
+		"MAX_PROCS":          &c.MaxProcs,
+		"PAGE_SIZE":          &c.PageSize,
+		"LOCAL_TRANSCODERS":  &c.LocalTranscoders,
+		"WORKERS":            &c.Workers,
+		"VISIBILITY_TIMEOUT": &c.VisibilityTimeout,
+	}
+	for name, field := range ints {
+		if value := getenv(name); value != "" {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%v should be a number, not %q", name, value)
+			}
+			*field = i
+		}
+	}
+
+	bools := map[string]*bool{
+		"SERVE_MEDIA":   &c.ServeMedia,
+		"PERSIST_MEDIA": &c.PersistMedia,
+		"CHECK_LINKS":   &c.CheckLinks,
+
+		"COUNT_API_IMPRESSIONS": &c.CountAPIImpressions,
+		"TRUST_PROXY":           &c.TrustProxy,
+	}
+	for name, field := range bools {
+		if value := getenv(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%v should be true or false, not %q", name, value)
+			}
+			*field = b
+		}
+	}
+
+	if value := getenv("TRANSCODERS"); value != "" {
+		c.Transcoders = splitList(value)
+	}
+	return nil
+}
+
+// Validate returns an error describing every setting that's missing or
+// doesn't make sense.
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Port == "" {
+		problems = append(problems, "port is required")
+	}
+	if c.DatabaseURL == "" {
+		problems = append(problems, "database url is required")
+	}
+	if c.QueryTimeout < 1 {
+		problems = append(problems, "query timeout should be at least 1 second")
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("base url %q should be absolute", c.BaseURL))
+	}
+	if c.MaxProcs < 1 {
+		problems = append(problems, "max procs should be at least 1")
+	}
+	if c.PageSize < 1 {
+		problems = append(problems, "page size should be at least 1")
+	}
+	if c.Workers < 1 {
+		problems = append(problems, "workers should be at least 1")
+	}
+	if c.VisibilityTimeout < 1 {
+		problems = append(problems, "visibility timeout should be at least 1 second")
+	}
+	if c.LocalTranscoders < 0 {
+		problems = append(problems, "local transcoders can't be negative")
+	}
+	if c.LocalTranscoders == 0 && len(c.Transcoders) == 0 {
+		problems = append(problems, "transcoders are required unless local transcoders are used")
+	}
+	if c.S3Bucket != "" && (c.S3AccessKeyID == "" || c.S3SecretAccessKey == "") {
+		problems = append(problems, "s3 access key id and secret access key are required with an s3 bucket")
+	}
+	if c.S3Bucket != "" && c.S3BaseURL != "" {
+		if u, err := url.Parse(c.S3BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("s3 base url %q should be absolute", c.S3BaseURL))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// URL returns path on the site, as an absolute url.
+func (c *Config) URL(path string) string {
+	return strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// MediaURL is where stored media is served from. Media links end up in the
+// api and in markdown shared elsewhere, so it's always absolute.
+func (c *Config) MediaURL() string {
+	if c.S3Bucket != "" && c.S3BaseURL != "" {
+		return strings.TrimRight(c.S3BaseURL, "/")
+	}
+	return c.URL("/media")
+}
+
+// TranscoderList is a flag.Value for setting Transcoders from a comma
+// separated list of hosts.
+type TranscoderList []string
+
+func (l *TranscoderList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *TranscoderList) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
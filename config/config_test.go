@@ -0,0 +1,164 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func load(args []string, env map[string]string) (*Config, error) {
+	config := Default()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.StringVar(&config.Port, "port", config.Port, "")
+	flags.Var((*TranscoderList)(&config.Transcoders), "transcoders", "")
+	err := config.load(flags, args, func(name string) string {
+		return env[name]
+	})
+	return config, err
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	directory, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	file := filepath.Join(directory, "config.json")
+	err = ioutil.WriteFile(file, []byte(`{"port": "1000", "page_size": 50, "base_url": "http://file.example.com"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	examples := []struct {
+		args     []string
+		env      map[string]string
+		port     string
+		pageSize int
+		baseURL  string
+	}{
+		{
+			port: "8080", pageSize: 20, baseURL: "http://ancientcitadel.com",
+		},
+		{
+			args: []string{"-config", file},
+			port: "1000", pageSize: 50, baseURL: "http://file.example.com",
+		},
+		{
+			env:  map[string]string{"CONFIG_FILE": file, "PORT": "2000", "PAGE_SIZE": "10"},
+			port: "2000", pageSize: 10, baseURL: "http://file.example.com",
+		},
+		{
+			args: []string{"-config", file, "-port", "3000"},
+			env:  map[string]string{"PORT": "2000", "BASE_URL": "http://env.example.com"},
+			port: "3000", pageSize: 50, baseURL: "http://env.example.com",
+		},
+	}
+
+	for _, example := range examples {
+		config, err := load(example.args, example.env)
+		if err != nil {
+			t.Errorf("Expected no error for %v %v\nGot:\n%v\n", example.args, example.env, err)
+			continue
+		}
+		if config.Port != example.port || config.PageSize != example.pageSize || config.BaseURL != example.baseURL {
+			t.Errorf("Expected:\n%v %v %v\nGot:\n%v %v %v\n",
+				example.port, example.pageSize, example.baseURL,
+				config.Port, config.PageSize, config.BaseURL)
+		}
+	}
+}
+
+func TestLoadTranscoders(t *testing.T) {
+	examples := []struct {
+		args     []string
+		env      map[string]string
+		expected []string
+	}{
+		{
+			env:      map[string]string{"TRANSCODERS": "http://a, http://b"},
+			expected: []string{"http://a", "http://b"},
+		},
+		{
+			args:     []string{"-transcoders", "http://c"},
+			env:      map[string]string{"TRANSCODERS": "http://a,http://b"},
+			expected: []string{"http://c"},
+		},
+	}
+
+	for _, example := range examples {
+		config, err := load(example.args, example.env)
+		if err != nil {
+			t.Errorf("Expected no error\nGot:\n%v\n", err)
+			continue
+		}
+		if !reflect.DeepEqual(config.Transcoders, example.expected) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.expected, config.Transcoders)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	examples := []struct {
+		env      map[string]string
+		expected string
+	}{
+		{map[string]string{"PAGE_SIZE": "lots"}, `PAGE_SIZE should be a number, not "lots"`},
+		{map[string]string{"CHECK_LINKS": "maybe"}, `CHECK_LINKS should be true or false, not "maybe"`},
+		{map[string]string{"PAGE_SIZE": "0"}, "page size should be at least 1"},
+		{map[string]string{"VISIBILITY_TIMEOUT": "0"}, "visibility timeout should be at least 1 second"},
+		{map[string]string{"BASE_URL": "ancientcitadel.com"}, `base url "ancientcitadel.com" should be absolute`},
+		{map[string]string{"S3_BUCKET": "media"}, "s3 access key id and secret access key are required"},
+		{map[string]string{"TRANSCODERS": ","}, "transcoders are required"},
+		{
+			map[string]string{"S3_BUCKET": "media", "S3_ACCESS_KEY_ID": "a", "S3_SECRET_ACCESS_KEY": "b", "S3_BASE_URL": "/media"},
+			`s3 base url "/media" should be absolute`,
+		},
+	}
+
+	for _, example := range examples {
+		_, err := load(nil, example.env)
+		if err == nil || !strings.Contains(err.Error(), example.expected) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.expected, err)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	examples := []struct {
+		baseURL  string
+		path     string
+		expected string
+	}{
+		{"http://ancientcitadel.com", "/gif/1-cat", "http://ancientcitadel.com/gif/1-cat"},
+		{"http://ancientcitadel.com/", "/gif/1-cat", "http://ancientcitadel.com/gif/1-cat"},
+		{"http://ancientcitadel.com", "twitter/callback", "http://ancientcitadel.com/twitter/callback"},
+	}
+
+	for _, example := range examples {
+		config := Config{BaseURL: example.baseURL}
+		if got := config.URL(example.path); got != example.expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.expected, got)
+		}
+	}
+}
+
+func TestMediaURL(t *testing.T) {
+	examples := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{BaseURL: "http://ancientcitadel.com"}, "http://ancientcitadel.com/media"},
+		{Config{BaseURL: "http://ancientcitadel.com", S3Bucket: "media"}, "http://ancientcitadel.com/media"},
+		{Config{BaseURL: "http://ancientcitadel.com", S3Bucket: "media", S3BaseURL: "https://cdn.example.com/"}, "https://cdn.example.com"},
+	}
+
+	for _, example := range examples {
+		if got := example.config.MediaURL(); got != example.expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.expected, got)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/AndrewVos/ancientcitadel/config"
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/reddit"
+	"github.com/gorilla/mux"
+)
+
+var validSourceName = regexp.MustCompile(`^\w{2,21}$`)
+
+type AdminController struct {
+	config *config.Config
+	store  db.Store
+}
+
+type PurgeResult struct {
+	Source  string `json:"source"`
+	Deleted int64  `json:"deleted"`
+}
+
+func NewAdminController(config *config.Config, store db.Store) *AdminController {
+	return &AdminController{config: config, store: store}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	w.Write(b)
+}
+
+func (c *AdminController) Sources(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sources, err := c.store.GetSources(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if len(sources) == 0 {
+		sources = []db.Source{}
+	}
+	writeJSON(w, sources)
+}
+
+func (c *AdminController) AddSource(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	source := db.Source{
+		Name:       r.FormValue("name"),
+		NSFW:       r.FormValue("nsfw") == "true",
+		Enabled:    true,
+		Sort:       r.FormValue("sort"),
+		TimeWindow: r.FormValue("time_window"),
+	}
+	if !validSourceName.MatchString(source.Name) {
+		rejectJSON(w, http.StatusBadRequest, "invalid subreddit name")
+		return
+	}
+	if source.Sort == "" {
+		source.Sort = reddit.Hot
+	}
+	if err := validateSort(source.Sort, source.TimeWindow); err != nil {
+		rejectJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if p := r.FormValue("priority"); p != "" {
+		source.Priority, _ = strconv.Atoi(p)
+	}
+	if i := r.FormValue("crawl_interval"); i != "" {
+		source.CrawlInterval, _ = strconv.Atoi(i)
+	}
+
+	existing, err := c.store.GetSource(r.Context(), source.Name)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if existing != nil {
+		rejectJSON(w, http.StatusConflict, "source already exists")
+		return
+	}
+
+	err = c.store.AddSource(r.Context(), &source)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, source)
+}
+
+func (c *AdminController) EnableSource(w http.ResponseWriter, r *http.Request) {
+	c.setSourceEnabled(w, r, true)
+}
+
+func (c *AdminController) DisableSource(w http.ResponseWriter, r *http.Request) {
+	c.setSourceEnabled(w, r, false)
+}
+
+func (c *AdminController) setSourceEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	c.updateSource(w, r, func(ctx context.Context, name string) error {
+		return c.store.SetSourceEnabled(ctx, name, enabled)
+	})
+}
+
+func (c *AdminController) SetSourceSort(w http.ResponseWriter, r *http.Request) {
+	sort := r.FormValue("sort")
+	timeWindow := r.FormValue("time_window")
+	if err := validateSort(sort, timeWindow); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		rejectJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.updateSource(w, r, func(ctx context.Context, name string) error {
+		return c.store.SetSourceSort(ctx, name, sort, timeWindow)
+	})
+}
+
+func (c *AdminController) BackfillSource(w http.ResponseWriter, r *http.Request) {
+	c.updateSource(w, r, c.store.RequestSourceBackfill)
+}
+
+func validateSort(sort string, timeWindow string) error {
+	if sort == "" || !reddit.ValidSort(sort) {
+		return errors.New("invalid sort")
+	}
+	if !reddit.ValidTimeWindow(timeWindow) {
+		return errors.New("invalid time window")
+	}
+	return nil
+}
+
+func (c *AdminController) updateSource(w http.ResponseWriter, r *http.Request, update func(ctx context.Context, name string) error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := mux.Vars(r)["name"]
+	err := update(r.Context(), name)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	source, err := c.store.GetSource(r.Context(), name)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if source == nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSONError(w, errors.New("no such source"))
+		return
+	}
+	writeJSON(w, source)
+}
+
+func (c *AdminController) BrokenURLs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page := adminPage(r)
+
+	urls, err := c.store.GetBrokenURLs(r.Context(), page, c.config.PageSize)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if len(urls) == 0 {
+		urls = []db.URL{}
+	}
+	writeJSON(w, urls)
+}
+
+type JobsResult struct {
+	Counts []db.JobCount `json:"counts"`
+	Jobs   []db.Job      `json:"jobs"`
+}
+
+// Jobs shows how many jobs there are in each status, along with the jobs
+// in the status given by the status parameter, failed by default.
+func (c *AdminController) Jobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = db.JobFailed
+	}
+	page := adminPage(r)
+
+	var result JobsResult
+	var err error
+	result.Counts, err = c.store.GetJobCounts(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	result.Jobs, err = c.store.GetJobs(r.Context(), status, page, c.config.PageSize)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if len(result.Counts) == 0 {
+		result.Counts = []db.JobCount{}
+	}
+	if len(result.Jobs) == 0 {
+		result.Jobs = []db.Job{}
+	}
+	writeJSON(w, result)
+}
+
+func (c *AdminController) FailedDownloads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page := adminPage(r)
+
+	results, err := c.store.GetFailedDownloads(r.Context(), page, c.config.PageSize)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if len(results) == 0 {
+		results = []db.DownloadResult{}
+	}
+	writeJSON(w, results)
+}
+
+func (c *AdminController) RequeueDownload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	url := r.FormValue("url")
+	requeued, err := c.store.RequeueDownload(r.Context(), url)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if !requeued {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSONError(w, errors.New("no failed download for that url"))
+		return
+	}
+
+	result, err := c.store.GetDownloadResult(r.Context(), url)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+func (c *AdminController) PurgeSource(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := mux.Vars(r)["name"]
+	deleted, err := c.store.PurgeSource(r.Context(), name)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, PurgeResult{Source: name, Deleted: deleted})
+}
+
+// adminPage reads which page of an admin list to show, which is always at
+// least the first.
+func adminPage(r *http.Request) int {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		return 1
+	}
+	return page
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/config"
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/gorilla/mux"
+)
+
+func newAdminTestRouter(store db.Store) *mux.Router {
+	adminController := NewAdminController(config.Default(), store)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/downloads/requeue", adminController.RequeueDownload).Methods("POST")
+	r.HandleFunc("/admin/sources", adminController.Sources).Methods("GET")
+	r.HandleFunc("/admin/sources", adminController.AddSource).Methods("POST")
+	r.HandleFunc("/admin/sources/{name}/enable", adminController.EnableSource).Methods("POST")
+	r.HandleFunc("/admin/sources/{name}/disable", adminController.DisableSource).Methods("POST")
+	r.HandleFunc("/admin/sources/{name}/sort", adminController.SetSourceSort).Methods("POST")
+	r.HandleFunc("/admin/sources/{name}/backfill", adminController.BackfillSource).Methods("POST")
+	return r
+}
+
+func TestAdminControllerSources(t *testing.T) {
+	store := db.NewMemory()
+	router := newAdminTestRouter(store)
+
+	examples := []struct {
+		method   string
+		path     string
+		expected int
+	}{
+		{"POST", "/admin/sources?name=gifs&crawl_interval=60", http.StatusOK},
+		{"POST", "/admin/sources?name=GIFS", http.StatusConflict},
+		{"POST", "/admin/sources?name=x", http.StatusBadRequest},
+		{"POST", "/admin/sources?name=cats&sort=bogus", http.StatusBadRequest},
+		{"POST", "/admin/sources?name=cats&sort=top&time_window=forever", http.StatusBadRequest},
+		{"POST", "/admin/sources/Gifs/disable", http.StatusOK},
+		{"POST", "/admin/sources/gifs/sort?sort=top&time_window=week", http.StatusOK},
+		{"POST", "/admin/sources/gifs/sort?sort=bogus", http.StatusBadRequest},
+		{"POST", "/admin/sources/gifs/backfill", http.StatusOK},
+		{"POST", "/admin/sources/dogs/enable", http.StatusNotFound},
+		{"GET", "/admin/sources", http.StatusOK},
+	}
+
+	for _, example := range examples {
+		w := request(router, example.method, example.path)
+		if w.Code != example.expected {
+			t.Errorf("Expected %v %v to respond with:\n%v\nGot:\n%v\n%v\n", example.method, example.path, example.expected, w.Code, w.Body.String())
+		}
+	}
+
+	var sources []db.Source
+	if err := json.Unmarshal(get(router, "/admin/sources").Body.Bytes(), &sources); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 {
+		t.Fatalf("Expected one source\nGot:\n%v\n", sources)
+	}
+
+	source, err := store.GetSource(context.Background(), "gifs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := db.Source{
+		ID:            source.ID,
+		CreatedAt:     source.CreatedAt,
+		Name:          "gifs",
+		Enabled:       false,
+		CrawlInterval: 60,
+		Sort:          "top",
+		TimeWindow:    "week",
+	}
+	if *source != expected {
+		t.Errorf("Expected:\n%+v\nGot:\n%+v\n", expected, *source)
+	}
+}
+
+func TestAdminControllerRequeueDownload(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemory()
+	router := newAdminTestRouter(store)
+	failed := db.URL{Title: "a", URL: "http://example.com/a.gif"}
+	store.StoreDownloadFailure(ctx, failed, "not found", true, time.Minute)
+
+	if w := request(router, "POST", "/admin/downloads/requeue?url=http://example.com/b.gif"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", http.StatusNotFound, w.Code)
+	}
+	if w := request(router, "POST", "/admin/downloads/requeue?url=http://example.com/a.gif"); w.Code != http.StatusOK {
+		t.Fatalf("Expected:\n%v\nGot:\n%v\n%v\n", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	job, err := store.ClaimJob(ctx, time.Minute)
+	if err != nil || job == nil {
+		t.Fatalf("Expected a job to be queued\nGot:\n%v %v\n", job, err)
+	}
+	url, err := job.DecodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.URL != failed.URL || url.Title != failed.Title {
+		t.Errorf("Expected:\n%+v\nGot:\n%+v\n", failed, url)
+	}
+}
+
+func TestAdminPage(t *testing.T) {
+	examples := []struct {
+		query    string
+		expected int
+	}{
+		{"", 1},
+		{"?page=3", 3},
+		{"?page=0", 1},
+		{"?page=-2", 1},
+		{"?page=lots", 1},
+	}
+
+	for _, example := range examples {
+		r := httptest.NewRequest("GET", "/admin/jobs"+example.query, nil)
+		if actual := adminPage(r); actual != example.expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.expected, actual)
+		}
+	}
+}
@@ -2,22 +2,38 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/AndrewVos/ancientcitadel/config"
 	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/viewcount"
 	"github.com/gorilla/mux"
 )
 
-type APIController struct{}
+type APIController struct {
+	config  *config.Config
+	store   db.Store
+	views   *viewcount.Recorder
+	limiter *viewcount.Limiter
+}
 
 type JSONError struct {
 	Error string `json:"error"`
 }
 
-func NewAPIController() *APIController {
-	return &APIController{}
+func NewAPIController(config *config.Config, store db.Store, views *viewcount.Recorder) *APIController {
+	limiter := viewcount.NewLimiter(60, time.Minute)
+	limiter.TrustProxy = config.TrustProxy
+	return &APIController{
+		config:  config,
+		store:   store,
+		views:   views,
+		limiter: limiter,
+	}
 }
 
 func writeJSONError(w http.ResponseWriter, err error) {
@@ -27,9 +43,28 @@ func writeJSONError(w http.ResponseWriter, err error) {
 	return
 }
 
+// rejectJSON responds with status and message without logging anything,
+// for requests that are the client's fault and that it can send as often
+// as it likes.
+func rejectJSON(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	b, _ := json.Marshal(JSONError{Error: message})
+	w.Write(b)
+}
+
+// compatible fills in WEBMURL for gifs that only come as mp4, since older
+// clients, like the chrome extension, only ever play WEBMURL and browsers
+// play the mp4 anyway.
+func compatible(url db.URL) db.URL {
+	if url.WEBMURL == "" {
+		url.WEBMURL = url.MP4URL
+	}
+	return url
+}
+
 func (c *APIController) Docs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	err := templates.ExecuteTemplate(w, "api", nil)
+	err := templates.ExecuteTemplate(w, "api", Result{SortByNew: true})
 	if err != nil {
 		writeError(err, w)
 	}
@@ -40,30 +75,53 @@ func (c *APIController) Index(w http.ResponseWriter, r *http.Request) {
 
 	nsfw := mux.Vars(r)["work"] == "nsfw"
 	order := mux.Vars(r)["order"]
-	page := 1
-	if p := r.URL.Query().Get("page"); p != "" {
-		page, _ = strconv.Atoi(p)
-	}
 	query := r.URL.Query().Get("q")
+	filter := db.Filter{
+		SubReddit: mux.Vars(r)["subreddit"],
+		Author:    mux.Vars(r)["author"],
+	}
+
+	page, err := parsePage(r, c.config.PageSize)
+	if err != nil {
+		rejectJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	var err error
 	urls := []db.URL{}
+	var next *db.Cursor
 
 	if order == "new" || order == "" {
-		urls, err = db.GetURLs(query, nsfw, page, PageSize)
+		urls, next, err = c.store.GetURLs(r.Context(), query, filter, nsfw, page)
 	} else if order == "top" {
-		urls, err = db.GetTopURLs(nsfw, page, PageSize)
+		urls, next, err = c.store.GetTopURLs(r.Context(), filter, nsfw, topWindow(r), page)
+	} else if order == "trending" {
+		urls, next, err = c.store.GetTrendingURLs(r.Context(), filter, nsfw, page)
 	} else if order == "shuffle" {
-		urls, err = db.GetShuffledURLs(nsfw, page, PageSize)
+		seed := parseSeed(r, page)
+		w.Header().Set("X-Shuffle-Seed", strconv.FormatFloat(seed, 'f', -1, 64))
+		urls, next, err = c.store.GetShuffledURLs(r.Context(), filter, nsfw, seed, page)
 	}
 	if err != nil {
 		writeJSONError(w, err)
 		return
 	}
 
+	if link := nextPageLink(r, next); link != "" {
+		w.Header().Set("X-Next-Cursor", next.String())
+		w.Header().Set("Link", fmt.Sprintf("<%v%v>; rel=\"next\"", r.URL.Path, link))
+	}
+
 	if len(urls) == 0 {
 		urls = []db.URL{}
 	}
+	if c.config.CountAPIImpressions {
+		for _, url := range urls {
+			c.views.Record(r, url)
+		}
+	}
+	for i := range urls {
+		urls[i] = compatible(urls[i])
+	}
 	b, err := json.Marshal(urls)
 	if err != nil {
 		writeJSONError(w, err)
@@ -77,20 +135,75 @@ func (c *APIController) Index(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (c *APIController) SubReddits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	nsfw := mux.Vars(r)["work"] == "nsfw"
+
+	counts, err := c.store.GetSubRedditCounts(r.Context(), nsfw)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if len(counts) == 0 {
+		counts = []db.SubRedditCount{}
+	}
+	b, err := json.Marshal(counts)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	w.Write(b)
+}
+
 func (c *APIController) Random(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	nsfw := mux.Vars(r)["work"] == "nsfw"
 
-	url, err := db.GetRandomURL(nsfw)
+	url, err := c.store.GetRandomURL(r.Context(), nsfw)
 	if err != nil {
 		writeJSONError(w, err)
 		return
 	}
-	b, err := json.MarshalIndent(url, " ", "")
+	if c.config.CountAPIImpressions {
+		c.views.Record(r, url)
+	}
+	b, err := json.MarshalIndent(compatible(url), " ", "")
 	if err != nil {
 		writeJSONError(w, err)
 		return
 	}
 	w.Write(b)
 }
+
+// StoreView is a beacon that pages send when a gif is played, so that gifs
+// watched on the listings count as views too. Views go through the same
+// checks as the ones from showing a gif, so playing one and then opening
+// it only counts once.
+func (c *APIController) StoreView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !c.limiter.Allow(r) {
+		rejectJSON(w, http.StatusTooManyRequests, "too many views")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rejectJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	url, err := c.store.GetURL(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if url == nil {
+		rejectJSON(w, http.StatusNotFound, "no such gif")
+		return
+	}
+
+	c.views.Record(r, *url)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/config"
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/viewcount"
+)
+
+func titles(t *testing.T, body []byte) []string {
+	var urls []db.URL
+	if err := json.Unmarshal(body, &urls); err != nil {
+		t.Fatalf("couldn't decode %s: %v", body, err)
+	}
+	titles := []string{}
+	for _, url := range urls {
+		titles = append(titles, url.Title)
+	}
+	return titles
+}
+
+func TestAPIControllerIndex(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif", SubReddit: "cats"},
+		db.URL{Title: "b", URL: "http://example.com/2.gif", SubReddit: "dogs"},
+		db.URL{Title: "c", URL: "http://example.com/3.gif", SubReddit: "cats"},
+		db.URL{Title: "d", URL: "http://example.com/4.gif", NSFW: true},
+	)
+	// Views only count towards the windows once their hour is over.
+	viewedAt := time.Now().Add(-time.Hour)
+	store.StoreURLViews(context.Background(), []db.View{
+		{URLID: 1, CreatedAt: viewedAt},
+		{URLID: 1, CreatedAt: viewedAt},
+		{URLID: 2, CreatedAt: viewedAt},
+	})
+	router, _ := newTestRouter(store)
+
+	examples := []struct {
+		path     string
+		expected []string
+	}{
+		{"/api/sfw", []string{"c", "b"}},
+		{"/api/sfw/new?page=2", []string{"a"}},
+		{"/api/sfw/new?page=3", []string{}},
+		{"/api/nsfw", []string{"d"}},
+		{"/api/sfw/top", []string{"a", "b"}},
+		{"/api/sfw/top/day", []string{"a", "b"}},
+		{"/api/sfw/top/all?page=2", []string{"c"}},
+		{"/api/sfw/trending", []string{"a", "b"}},
+		{"/api/sfw/source/cats", []string{"c", "a"}},
+	}
+
+	for _, example := range examples {
+		w := get(router, example.path)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected %v to respond with:\n%v\nGot:\n%v\n", example.path, http.StatusOK, w.Code)
+			continue
+		}
+		actual := titles(t, w.Body.Bytes())
+		if !reflect.DeepEqual(actual, example.expected) {
+			t.Errorf("Expected %v to return:\n%v\nGot:\n%v\n", example.path, example.expected, actual)
+		}
+	}
+}
+
+func TestAPIControllerCursors(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif"},
+		db.URL{Title: "b", URL: "http://example.com/2.gif"},
+		db.URL{Title: "c", URL: "http://example.com/3.gif"},
+		db.URL{Title: "d", URL: "http://example.com/4.gif"},
+		db.URL{Title: "e", URL: "http://example.com/5.gif"},
+	)
+	router, _ := newTestRouter(store)
+
+	var pages [][]string
+	path := "/api/sfw"
+	for path != "" {
+		w := get(router, path)
+		pages = append(pages, titles(t, w.Body.Bytes()))
+		path = ""
+		if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+			path = "/api/sfw?after=" + cursor
+		}
+	}
+
+	expected := [][]string{{"e", "d"}, {"c", "b"}, {"a"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, pages)
+	}
+
+	w := get(router, "/api/sfw/top?after=nonsense")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAPIControllerShuffle(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif", Random: 0.5},
+		db.URL{Title: "b", URL: "http://example.com/2.gif", Random: 0.1},
+		db.URL{Title: "c", URL: "http://example.com/3.gif", Random: 0.9},
+		db.URL{Title: "d", URL: "http://example.com/4.gif", Random: 0.7},
+		db.URL{Title: "e", URL: "http://example.com/5.gif", Random: 0.3},
+	)
+	router, _ := newTestRouter(store)
+
+	examples := []struct {
+		seed     string
+		expected [][]string
+	}{
+		{"0", [][]string{{"b", "e"}, {"a", "d"}, {"c"}}},
+		{"0.6", [][]string{{"d", "c"}, {"b", "e"}, {"a"}}},
+		{"0.95", [][]string{{"b", "e"}, {"a", "d"}, {"c"}}},
+	}
+
+	for _, example := range examples {
+		var pages [][]string
+		path := "/api/sfw/shuffle?seed=" + example.seed
+		for path != "" {
+			w := get(router, path)
+			if seed := w.Header().Get("X-Shuffle-Seed"); seed != example.seed {
+				t.Errorf("Expected:\n%v\nGot:\n%v\n", example.seed, seed)
+			}
+			pages = append(pages, titles(t, w.Body.Bytes()))
+			path = ""
+			if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+				path = "/api/sfw/shuffle?after=" + cursor
+			}
+		}
+		if !reflect.DeepEqual(pages, example.expected) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.expected, pages)
+		}
+	}
+}
+
+func TestAPIControllerSubReddits(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif", SubReddit: "cats"},
+		db.URL{Title: "b", URL: "http://example.com/2.gif", SubReddit: "dogs"},
+		db.URL{Title: "c", URL: "http://example.com/3.gif", SubReddit: "cats"},
+	)
+	router, _ := newTestRouter(store)
+
+	var actual []db.SubRedditCount
+	if err := json.Unmarshal(get(router, "/api/sfw/sources").Body.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := []db.SubRedditCount{{Name: "cats", Count: 2}, {Name: "dogs", Count: 1}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestAPIControllerRandom(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif"},
+		db.URL{Title: "b", URL: "http://example.com/2.gif", NSFW: true},
+	)
+	router, _ := newTestRouter(store)
+
+	var actual db.URL
+	if err := json.Unmarshal(get(router, "/api/random/nsfw").Body.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.Title != "b" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "b", actual.Title)
+	}
+}
+
+func TestAPIControllerRandomVideoHasPlayableWEBMURL(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.mp4", MP4URL: "http://example.com/1.mp4"},
+	)
+	router, _ := newTestRouter(store)
+
+	var actual db.URL
+	if err := json.Unmarshal(get(router, "/api/random/sfw").Body.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.WEBMURL != "http://example.com/1.mp4" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "http://example.com/1.mp4", actual.WEBMURL)
+	}
+}
+
+func TestAPIControllerStoreView(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif"},
+	)
+	router, views := newTestRouter(store)
+
+	examples := []struct {
+		path     string
+		expected int
+	}{
+		{"/api/views/1", http.StatusNoContent},
+		{"/api/views/1", http.StatusNoContent},
+		{"/api/views/2", http.StatusNotFound},
+	}
+	for _, example := range examples {
+		w := request(router, "POST", example.path)
+		if w.Code != example.expected {
+			t.Errorf("Expected %v to respond with:\n%v\nGot:\n%v\n", example.path, example.expected, w.Code)
+		}
+	}
+
+	if err := views.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	url, err := store.GetURL(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.Views != 1 {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", 1, url.Views)
+	}
+
+	code := 0
+	for i := 0; i < 100 && code != http.StatusTooManyRequests; i++ {
+		code = request(router, "POST", "/api/views/1").Code
+	}
+	if code != http.StatusTooManyRequests {
+		t.Errorf("Expected views to be rate limited")
+	}
+}
+
+func TestAPIControllerImpressions(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif"},
+		db.URL{Title: "b", URL: "http://example.com/2.gif"},
+	)
+	views := viewcount.NewRecorder(store)
+
+	for _, count := range []bool{false, true} {
+		config := config.Default()
+		config.CountAPIImpressions = count
+		controller := NewAPIController(config, store, views)
+
+		r := httptest.NewRequest("GET", "/api/sfw", nil)
+		r.Header.Set("User-Agent", "Mozilla/5.0")
+		controller.Index(httptest.NewRecorder(), r)
+		if err := views.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		url, err := store.GetURL(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 0
+		if count {
+			expected = 1
+		}
+		if url.Views != expected {
+			t.Errorf("Expected impressions to be counted %v times\nGot:\n%v\n", expected, url.Views)
+		}
+	}
+}
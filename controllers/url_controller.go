@@ -3,38 +3,77 @@ package controllers
 import (
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"text/template"
 
+	"github.com/AndrewVos/ancientcitadel/config"
 	"github.com/AndrewVos/ancientcitadel/db"
 	"github.com/AndrewVos/ancientcitadel/slug"
+	"github.com/AndrewVos/ancientcitadel/viewcount"
 	"github.com/dustin/go-humanize"
 	"github.com/gorilla/mux"
 )
 
-var templates = template.Must(template.ParseGlob("views/*"))
+var templates *template.Template
 
-const (
-	PageSize = 20
-)
+// ParseTemplates parses the views the controllers render, which must
+// happen before any of them are used.
+func ParseTemplates(pattern string) error {
+	t, err := template.ParseGlob(pattern)
+	if err != nil {
+		return err
+	}
+	templates = t
+	return nil
+}
 
-type URLController struct{}
+type URLController struct {
+	config *config.Config
+	store  db.Store
+	views  *viewcount.Recorder
+}
 
-func NewURLController() *URLController {
-	return &URLController{}
+func NewURLController(config *config.Config, store db.Store, views *viewcount.Recorder) *URLController {
+	return &URLController{config: config, store: store, views: views}
 }
 
 type Result struct {
 	HumanCount          string
 	SortByTop           bool
+	SortByTrending      bool
 	SortByShuffle       bool
 	SortByNew           bool
+	TopWindow           string
 	NSFW                bool
 	Query               string
+	SubReddit           string
+	Author              string
 	ShowAgeVerification bool
 }
 
+// ListingPath returns the path to the current listing sorted by order,
+// keeping the adult mode and any subreddit or author filter.
+func (r Result) ListingPath(order string) string {
+	path := ""
+	if r.NSFW {
+		path = "/nsfw"
+	}
+	if r.SubReddit != "" {
+		path += "/source/" + r.SubReddit
+	} else if r.Author != "" {
+		path += "/author/" + r.Author
+	}
+	if order != "" {
+		path += "/" + order
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
 type IndexResult struct {
 	Result
 	CurrentPage  int
@@ -42,16 +81,80 @@ type IndexResult struct {
 	URLs         []db.URL
 }
 
+type SubRedditsResult struct {
+	Result
+	SubReddits []db.SubRedditCount
+}
+
 type ShowResult struct {
 	Result
 	URL db.URL
 }
 
+// parsePage reads which page of a listing to show from the after cursor,
+// or from the page number that older links use.
+func parsePage(r *http.Request, size int) (db.Page, error) {
+	page := db.Page{Number: 1, Size: size}
+	if after := r.URL.Query().Get("after"); after != "" {
+		cursor, err := db.ParseCursor(after)
+		if err != nil {
+			return page, err
+		}
+		page.After = cursor
+	} else if p := r.URL.Query().Get("page"); p != "" {
+		page.Number, _ = strconv.Atoi(p)
+		if page.Number < 1 {
+			page.Number = 1
+		}
+	}
+	return page, nil
+}
+
+// parseSeed reads the seed a shuffled listing was started with, from the
+// cursor when there is one, picking a new one when there isn't so that
+// every visit gets a different shuffle.
+func parseSeed(r *http.Request, page db.Page) float64 {
+	if page.After != nil {
+		return page.After.Seed
+	}
+	seed, err := strconv.ParseFloat(r.URL.Query().Get("seed"), 64)
+	if err != nil || seed < 0 || seed >= 1 {
+		return rand.Float64()
+	}
+	return seed
+}
+
+// topWindow reads which window a top listing is limited to, which is all
+// time when there isn't one.
+func topWindow(r *http.Request) string {
+	if window := mux.Vars(r)["window"]; window != "" {
+		return window
+	}
+	return db.TopAll
+}
+
+// nextPageLink links to the page that carries on after next. It's empty
+// on the last page.
+func nextPageLink(r *http.Request, next *db.Cursor) string {
+	if next == nil {
+		return ""
+	}
+	q := r.URL.Query()
+	q.Del("page")
+	q.Set("after", next.String())
+	return "?" + q.Encode()
+}
+
 func writeError(err error, w http.ResponseWriter) {
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 	log.Println(err)
 }
 
+// rejectHTML is rejectJSON for pages.
+func rejectHTML(w http.ResponseWriter, status int, message string) {
+	http.Error(w, message, status)
+}
+
 func (c *URLController) Show(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	result := ShowResult{}
@@ -63,14 +166,14 @@ func (c *URLController) Show(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url, err := db.GetURL(id)
+	url, err := c.store.GetURL(r.Context(), id)
 	if err != nil {
 		writeError(err, w)
 		return
 	}
 	if url == nil {
+		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404"))
-		w.WriteHeader(404)
 		return
 	}
 	result.URL = *url
@@ -83,11 +186,7 @@ func (c *URLController) Show(w http.ResponseWriter, r *http.Request) {
 		result.ShowAgeVerification = result.NSFW
 	}
 
-	err = db.StoreURLView(*url)
-	if err != nil {
-		writeError(err, w)
-		return
-	}
+	c.views.Record(r, *url)
 
 	err = templates.ExecuteTemplate(w, "show", result)
 	if err != nil {
@@ -100,14 +199,18 @@ func (c *URLController) Index(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	result := IndexResult{}
 
-	count, err := db.GetURLCount()
+	count, err := c.store.GetURLCount(r.Context())
 	result.HumanCount = fmt.Sprintf("%s", humanize.Comma(int64(count)))
 
 	result.SortByTop = mux.Vars(r)["top"] == "top"
+	result.SortByTrending = mux.Vars(r)["trending"] == "trending"
 	result.SortByShuffle = mux.Vars(r)["shuffle"] == "shuffle"
-	result.SortByNew = !result.SortByTop && !result.SortByShuffle
+	result.SortByNew = !result.SortByTop && !result.SortByTrending && !result.SortByShuffle
 	result.NSFW = mux.Vars(r)["work"] == "nsfw"
 	result.Query = r.URL.Query().Get("q")
+	result.SubReddit = mux.Vars(r)["subreddit"]
+	result.Author = mux.Vars(r)["author"]
+	filter := db.Filter{SubReddit: result.SubReddit, Author: result.Author}
 
 	verified := mux.Vars(r)["age-verified"] == "yes"
 	if verified {
@@ -116,28 +219,29 @@ func (c *URLController) Index(w http.ResponseWriter, r *http.Request) {
 		result.ShowAgeVerification = result.NSFW
 	}
 
-	if p := r.URL.Query().Get("page"); p != "" {
-		currentPage, _ := strconv.Atoi(p)
-		result.CurrentPage = currentPage
-	} else {
-		result.CurrentPage = 1
+	page, err := parsePage(r, c.config.PageSize)
+	if err != nil {
+		rejectHTML(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	result.CurrentPage = page.Number
 
-	q := r.URL.Query()
-	q.Set("page", fmt.Sprintf("%v", result.CurrentPage+1))
-	result.NextPageLink = "?" + q.Encode()
-
+	var next *db.Cursor
 	if result.SortByTop {
-		result.URLs, err = db.GetTopURLs(result.NSFW, result.CurrentPage, PageSize)
+		result.TopWindow = topWindow(r)
+		result.URLs, next, err = c.store.GetTopURLs(r.Context(), filter, result.NSFW, result.TopWindow, page)
+	} else if result.SortByTrending {
+		result.URLs, next, err = c.store.GetTrendingURLs(r.Context(), filter, result.NSFW, page)
 	} else if result.SortByShuffle {
-		result.URLs, err = db.GetShuffledURLs(result.NSFW, result.CurrentPage, PageSize)
+		result.URLs, next, err = c.store.GetShuffledURLs(r.Context(), filter, result.NSFW, parseSeed(r, page), page)
 	} else {
-		result.URLs, err = db.GetURLs(result.Query, result.NSFW, result.CurrentPage, PageSize)
+		result.URLs, next, err = c.store.GetURLs(r.Context(), result.Query, filter, result.NSFW, page)
 	}
 	if err != nil {
 		writeError(err, w)
 		return
 	}
+	result.NextPageLink = nextPageLink(r, next)
 
 	err = templates.ExecuteTemplate(w, "index", result)
 	if err != nil {
@@ -145,3 +249,29 @@ func (c *URLController) Index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (c *URLController) SubReddits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	result := SubRedditsResult{}
+	result.NSFW = mux.Vars(r)["work"] == "nsfw"
+
+	verified := mux.Vars(r)["age-verified"] == "yes"
+	if verified {
+		result.ShowAgeVerification = false
+	} else {
+		result.ShowAgeVerification = result.NSFW
+	}
+
+	var err error
+	result.SubReddits, err = c.store.GetSubRedditCounts(r.Context(), result.NSFW)
+	if err != nil {
+		writeError(err, w)
+		return
+	}
+
+	err = templates.ExecuteTemplate(w, "sources", result)
+	if err != nil {
+		writeError(err, w)
+		return
+	}
+}
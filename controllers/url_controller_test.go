@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/config"
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/viewcount"
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	if err := ParseTemplates("../views/*"); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestStore(t *testing.T, urls ...db.URL) *db.Memory {
+	store := db.NewMemory()
+	for i := range urls {
+		if urls[i].CreatedAt.IsZero() {
+			urls[i].CreatedAt = time.Date(2015, 7, 1, 0, 0, i, 0, time.UTC)
+		}
+		if err := store.SaveURL(context.Background(), &urls[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func newTestRouter(store db.Store) (*mux.Router, *viewcount.Recorder) {
+	config := config.Default()
+	config.PageSize = 2
+	views := viewcount.NewRecorder(store)
+	urlController := NewURLController(config, store, views)
+	apiController := NewAPIController(config, store, views)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/random/{work:nsfw|sfw}", apiController.Random)
+	r.HandleFunc("/api/views/{id:\\d+}", apiController.StoreView).Methods("POST")
+	r.HandleFunc("/api/{work:nsfw|sfw}/sources", apiController.SubReddits)
+	r.HandleFunc("/api/{work:nsfw|sfw}/source/{subreddit:\\w+}", apiController.Index)
+	r.HandleFunc("/api/{work:nsfw|sfw}/{order:new|top|trending|shuffle}", apiController.Index)
+	r.HandleFunc("/api/{work:nsfw|sfw}/{order:top}/{window:day|week|month|all}", apiController.Index)
+	r.HandleFunc("/api/{work:nsfw|sfw}", apiController.Index)
+	r.HandleFunc("/gif/{slug}", urlController.Show)
+	r.HandleFunc("/source/{subreddit:\\w+}", urlController.Index)
+	r.HandleFunc("/{work:nsfw}/sources", urlController.SubReddits)
+	r.HandleFunc("/sources", urlController.SubReddits)
+	r.HandleFunc("/{top:top}", urlController.Index)
+	r.HandleFunc("/{top:top}/{window:day|week|month|all}", urlController.Index)
+	r.HandleFunc("/{trending:trending}", urlController.Index)
+	r.HandleFunc("/{work:nsfw}", urlController.Index)
+	r.HandleFunc("/", urlController.Index)
+	return r, views
+}
+
+func get(router http.Handler, path string) *httptest.ResponseRecorder {
+	return request(router, "GET", path)
+}
+
+func request(router http.Handler, method string, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestURLControllerIndex(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "Oldest cat", URL: "http://example.com/1.gif", SubReddit: "cats"},
+		db.URL{Title: "Dancing dog", URL: "http://example.com/2.gif", SubReddit: "dogs"},
+		db.URL{Title: "Newest cat", URL: "http://example.com/3.gif", SubReddit: "cats"},
+		db.URL{Title: "Naughty cat", URL: "http://example.com/4.gif", NSFW: true},
+		db.URL{Title: "Broken cat", URL: "http://example.com/5.gif", LinkFailures: db.MaximumLinkFailures},
+	)
+	router, _ := newTestRouter(store)
+
+	examples := []struct {
+		path     string
+		expected []string
+		excluded []string
+	}{
+		{"/", []string{"Newest cat", "Dancing dog"}, []string{"Oldest cat", "Naughty cat", "Broken cat"}},
+		{"/?page=2", []string{"Oldest cat"}, []string{"Newest cat", "Dancing dog"}},
+		{"/?after=nonsense", nil, []string{"Newest cat"}},
+		{"/nsfw", nil, []string{"Naughty cat", "Newest cat", "Dancing dog"}},
+		{"/source/cats", []string{"Newest cat", "Oldest cat"}, []string{"Dancing dog"}},
+		{"/?q=dog", []string{"Dancing dog"}, []string{"Newest cat", "Oldest cat"}},
+		{"/?q=cat+-newest", []string{"Oldest cat"}, []string{"Newest cat", "Dancing dog"}},
+		{"/?q=dog+OR+%22oldest+cat%22", []string{"Dancing dog", "Oldest cat"}, []string{"Newest cat"}},
+		{"/?q=danc*", []string{"Dancing dog"}, []string{"Newest cat", "Oldest cat"}},
+	}
+
+	for _, example := range examples {
+		body := get(router, example.path).Body.String()
+		for _, title := range example.expected {
+			if !strings.Contains(body, title) {
+				t.Errorf("Expected %v to show:\n%v\n", example.path, title)
+			}
+		}
+		for _, title := range example.excluded {
+			if strings.Contains(body, title) {
+				t.Errorf("Expected %v not to show:\n%v\n", example.path, title)
+			}
+		}
+	}
+}
+
+func TestURLControllerNextPageLink(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "Oldest cat", URL: "http://example.com/1.gif"},
+		db.URL{Title: "Dancing dog", URL: "http://example.com/2.gif"},
+		db.URL{Title: "Newest cat", URL: "http://example.com/3.gif"},
+	)
+	router, _ := newTestRouter(store)
+
+	body := get(router, "/").Body.String()
+	link := regexp.MustCompile(`href="(\?after=[^"]+)"`).FindStringSubmatch(body)
+	if link == nil {
+		t.Fatalf("Expected a link to the next page, got:\n%v\n", body)
+	}
+
+	store.SaveURL(context.Background(), &db.URL{
+		Title:     "Brand new cat",
+		URL:       "http://example.com/4.gif",
+		CreatedAt: time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	body = get(router, "/"+html.UnescapeString(link[1])).Body.String()
+	if !strings.Contains(body, "Oldest cat") || strings.Contains(body, "Dancing dog") {
+		t.Errorf("Expected the next page to carry on after the first, got:\n%v\n", body)
+	}
+	if strings.Contains(body, "BRING FORTH MORE GIFS") {
+		t.Errorf("Expected no link after the last page")
+	}
+}
+
+func TestURLControllerShow(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "Dancing dog", URL: "http://example.com/1.gif"},
+	)
+	router, views := newTestRouter(store)
+
+	w := get(router, "/gif/1-dancing-dog")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected:\n%v\nGot:\n%v\n", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Dancing dog") {
+		t.Errorf("Expected the gif to be shown, got:\n%v\n", w.Body.String())
+	}
+	get(router, "/gif/1-dancing-dog")
+	if err := views.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := store.GetURL(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.Views != 1 {
+		t.Errorf("Expected one view to be stored\nGot:\n%v\n", url.Views)
+	}
+
+	w = get(router, "/gif/2-missing")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestURLControllerSubReddits(t *testing.T) {
+	store := newTestStore(t,
+		db.URL{Title: "a", URL: "http://example.com/1.gif", SubReddit: "cats"},
+		db.URL{Title: "b", URL: "http://example.com/2.gif", SubReddit: "dogs", NSFW: true},
+	)
+	router, _ := newTestRouter(store)
+
+	body := get(router, "/sources").Body.String()
+	if !strings.Contains(body, "cats") || strings.Contains(body, "dogs") {
+		t.Errorf("Expected only cats to be listed, got:\n%v\n", body)
+	}
+}
+
+func TestURLControllerInvalidCursor(t *testing.T) {
+	router, _ := newTestRouter(newTestStore(t))
+
+	w := get(router, "/?after=nonsense")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "invalid cursor") {
+		t.Errorf("Expected the error to be shown, got:\n%v\n", w.Body.String())
+	}
+}
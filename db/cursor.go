@@ -0,0 +1,107 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Listing orders that can be paged through with a Cursor.
+const (
+	OrderNew      = "new"
+	OrderTop      = "top"
+	OrderSearch   = "search"
+	OrderShuffle  = "shuffle"
+	OrderTrending = "trending"
+)
+
+// ErrInvalidCursor is returned for cursors that can't be parsed, or that
+// came from a listing in a different order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last gif on a page of a listing so that the next page
+// can carry on after it. Unlike page numbers, cursors don't skip or repeat
+// gifs when new ones are added while someone's scrolling, and they don't
+// get slower the further down the listing they are.
+type Cursor struct {
+	Order     string    `json:"o"`
+	CreatedAt time.Time `json:"c,omitempty"`
+	Views     int       `json:"v,omitempty"`
+	Rank      float64   `json:"r,omitempty"`
+	Seed      float64   `json:"s,omitempty"`
+	Random    float64   `json:"n,omitempty"`
+	Since     time.Time `json:"w,omitempty"`
+	Until     time.Time `json:"u,omitempty"`
+	ID        int       `json:"i"`
+}
+
+// String encodes the cursor as an opaque token for links.
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseCursor(token string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(b, &cursor); err != nil || cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// Page picks out part of a listing: the Size gifs after the After cursor
+// when it's set, or page Number otherwise, for old clients that still
+// page with ?page=.
+type Page struct {
+	Number int
+	Size   int
+	After  *Cursor
+}
+
+// after returns the cursor to carry on from in a listing with order, or
+// nil when paging by number.
+func (p Page) after(order string) (*Cursor, error) {
+	if p.After == nil {
+		return nil, nil
+	}
+	if p.After.Order != order {
+		return nil, ErrInvalidCursor
+	}
+	return p.After, nil
+}
+
+func (p Page) limit(args []interface{}) (string, []interface{}) {
+	if p.After != nil {
+		args = append(args, p.Size)
+		return fmt.Sprintf("LIMIT $%d", len(args)), args
+	}
+	number := p.Number
+	if number < 1 {
+		number = 1
+	}
+	args = append(args, p.Size, (number-1)*p.Size)
+	return fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args
+}
+
+// nextCursor returns the cursor for the page after urls, or nil when urls
+// was the last page.
+func nextCursor(order string, urls []URL, page Page) *Cursor {
+	if len(urls) == 0 || len(urls) < page.Size {
+		return nil
+	}
+	last := urls[len(urls)-1]
+	return &Cursor{
+		Order:     order,
+		CreatedAt: last.CreatedAt,
+		Views:     last.Views,
+		Rank:      last.Rank,
+		Random:    last.Random,
+		ID:        last.ID,
+	}
+}
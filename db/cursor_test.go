@@ -0,0 +1,50 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCursor(t *testing.T) {
+	cursor := Cursor{
+		Order:     OrderSearch,
+		CreatedAt: time.Date(2015, 7, 1, 12, 30, 0, 123456000, time.UTC),
+		Views:     12,
+		Rank:      0.1,
+		ID:        42,
+	}
+
+	actual, err := ParseCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*actual, cursor) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", cursor, *actual)
+	}
+
+	for _, token := range []string{"", "nonsense", "e30"} {
+		if _, err := ParseCursor(token); err != ErrInvalidCursor {
+			t.Errorf("Expected %q to be invalid\nGot:\n%v\n", token, err)
+		}
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	examples := []struct {
+		page     Page
+		expected string
+		args     []interface{}
+	}{
+		{Page{Number: 3, Size: 20}, "LIMIT $2 OFFSET $3", []interface{}{true, 20, 40}},
+		{Page{Size: 20}, "LIMIT $2 OFFSET $3", []interface{}{true, 20, 0}},
+		{Page{Number: 3, Size: 20, After: &Cursor{ID: 1}}, "LIMIT $2", []interface{}{true, 20}},
+	}
+
+	for _, example := range examples {
+		sql, args := example.page.limit([]interface{}{true})
+		if sql != example.expected || !reflect.DeepEqual(args, example.args) {
+			t.Errorf("Expected:\n%v %v\nGot:\n%v %v\n", example.expected, example.args, sql, args)
+		}
+	}
+}
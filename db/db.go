@@ -1,33 +1,48 @@
 package db
 
 import (
-	"os"
+	"context"
+	"time"
 
 	"github.com/AndrewVos/mig"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
 
-var database *sqlx.DB
+// DefaultQueryTimeout is how long queries can run for unless Postgres is
+// told otherwise.
+const DefaultQueryTimeout = 30 * time.Second
 
-func Migrate() error {
-	return mig.Migrate("postgres", databaseURL(), "./migrations")
+// Postgres is the Store the site runs on.
+type Postgres struct {
+	db *sqlx.DB
+	// QueryTimeout is how long any one query can run before it's
+	// cancelled.
+	QueryTimeout time.Duration
 }
 
-func databaseURL() string {
-	if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
-		return databaseURL
-	} else {
-		return "host=/var/run/postgresql dbname=ancientcitadel sslmode=disable"
+// NewPostgres connects a pool to the database at url.
+func NewPostgres(url string) (*Postgres, error) {
+	db, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		return nil, err
 	}
+	return &Postgres{db: db, QueryTimeout: DefaultQueryTimeout}, nil
 }
 
-func db() (*sqlx.DB, error) {
-	if database == nil {
-		db, err := sqlx.Connect("postgres", databaseURL())
-		database = db
-		return database, err
-	} else {
-		return database, database.Ping()
+// timeout limits a query to QueryTimeout, as well as to ctx.
+func (p *Postgres) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, p.QueryTimeout)
+}
+
+// Close waits for running queries to finish and closes the pool.
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+func Migrate(url string) error {
+	return mig.Migrate("postgres", url, "./migrations")
 }
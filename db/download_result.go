@@ -1,35 +1,141 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// MaximumDownloadAttempts is how many times a url is tried before its
+// failures are treated as permanent.
+const MaximumDownloadAttempts = 5
+
 type DownloadResult struct {
-	CreatedAt time.Time `db:"created_at"`
-	URL       string    `db:"url"`
-	Success   bool      `db:"success"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at" json:"updated_at"`
+	URL       string     `db:"url" json:"url"`
+	Success   bool       `db:"success" json:"success"`
+	Error     string     `db:"error" json:"error"`
+	Attempts  int        `db:"attempts" json:"attempts"`
+	Permanent bool       `db:"permanent" json:"permanent"`
+	RetryAt   *time.Time `db:"retry_at" json:"retry_at"`
+	// Payload is the url that failed, so that it can be queued again.
+	// Failures from before it was kept don't have one.
+	Payload []byte `db:"payload" json:"-"`
 }
 
-func StoreDownloadResult(url string, success bool) error {
-	db, err := db()
-	if err != nil {
-		return err
+// Blocked reports whether url shouldn't be downloaded again yet.
+func (r DownloadResult) Blocked(now time.Time) bool {
+	if r.Success {
+		return false
 	}
-	_, err = db.Exec(`INSERT INTO download_results (url, success) VALUES ($1, $2)`, url, success)
+	return r.Permanent || (r.RetryAt != nil && r.RetryAt.After(now))
+}
+
+func (p *Postgres) StoreDownloadSuccess(ctx context.Context, url string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	INSERT INTO download_results (url, success) VALUES ($1, true)
+		ON CONFLICT (url) DO UPDATE SET
+			success = true,
+			error = '',
+			attempts = download_results.attempts + 1,
+			permanent = false,
+			retry_at = NULL,
+			updated_at = now()`,
+		url)
 	return err
 }
 
-func GetDownloadResult(url string) (*DownloadResult, error) {
-	db, err := db()
+// StoreDownloadFailure records why url couldn't be downloaded. Transient
+// failures are retried after backoff, which doubles with every attempt,
+// until MaximumDownloadAttempts is reached.
+func (p *Postgres) StoreDownloadFailure(ctx context.Context, url URL, reason string, permanent bool, backoff time.Duration) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	payload, err := json.Marshal(url)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	_, err = p.db.ExecContext(ctx, `
+	INSERT INTO download_results (url, success, error, permanent, retry_at, payload)
+		VALUES ($1, false, $2, $3, now() + $4 * interval '1 second', $6)
+		ON CONFLICT (url) DO UPDATE SET
+			success = false,
+			error = $2,
+			attempts = download_results.attempts + 1,
+			permanent = $3 OR download_results.attempts + 1 >= $5,
+			retry_at = now() + $4 * power(2, download_results.attempts) * interval '1 second',
+			payload = $6,
+			updated_at = now()`,
+		url.URL, reason, permanent, backoff.Seconds(), MaximumDownloadAttempts, payload)
+	return err
+}
+
+func (p *Postgres) GetDownloadResult(ctx context.Context, url string) (*DownloadResult, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
 	var results []DownloadResult
-	err = db.Select(&results, `SELECT * FROM download_results WHERE url = $1 LIMIT 1`, url)
+	err := p.db.SelectContext(ctx, &results, `SELECT * FROM download_results WHERE url = $1 ORDER BY updated_at DESC LIMIT 1`, url)
 	if len(results) == 1 {
 		return &results[0], nil
 	}
 	return nil, err
 }
+
+// GetFailedDownloads returns failed downloads, most recent first.
+func (p *Postgres) GetFailedDownloads(ctx context.Context, page int, pageSize int) ([]DownloadResult, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var results []DownloadResult
+	err := p.db.SelectContext(ctx, &results, `
+		SELECT * FROM download_results
+			WHERE success = false
+			ORDER BY updated_at DESC
+			LIMIT $1 OFFSET $2`,
+		pageSize, (page-1)*pageSize)
+	return results, err
+}
+
+// RequeueDownload lets a failed url be tried again however it failed
+// before, and queues it straight away. Failures from before payloads were
+// kept are only tried again the next time they're crawled.
+func (p *Postgres) RequeueDownload(ctx context.Context, url string) (bool, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE download_results
+			SET permanent = false, attempts = 0, retry_at = NULL, updated_at = now()
+			WHERE url = $1 AND success = false`,
+		url)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO jobs (url, payload)
+		SELECT url, payload FROM download_results WHERE url = $1 AND payload IS NOT NULL
+		ON CONFLICT (url) WHERE status IN ('queued', 'running') DO NOTHING`,
+		url)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// MaximumJobAttempts is how many times a job can be claimed before it's
+// given up on, for jobs whose workers keep dying part way through.
+const MaximumJobAttempts = 5
+
+// Job is a url waiting in the queue to be downloaded and transcoded.
+type Job struct {
+	ID          int        `db:"id" json:"id"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+	URL         string     `db:"url" json:"url"`
+	Payload     []byte     `db:"payload" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	Attempts    int        `db:"attempts" json:"attempts"`
+	RunAt       time.Time  `db:"run_at" json:"run_at"`
+	LockedUntil *time.Time `db:"locked_until" json:"locked_until"`
+	LastError   string     `db:"last_error" json:"last_error"`
+}
+
+func (j Job) DecodeURL() (URL, error) {
+	var url URL
+	err := json.Unmarshal(j.Payload, &url)
+	return url, err
+}
+
+type JobCount struct {
+	Status string `db:"status" json:"status"`
+	Count  int    `db:"count" json:"count"`
+}
+
+// EnqueueURL adds url to the queue, unless it's already waiting there.
+func (p *Postgres) EnqueueURL(ctx context.Context, url URL) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	payload, err := json.Marshal(url)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `
+	INSERT INTO jobs (url, payload) VALUES ($1, $2)
+		ON CONFLICT (url) WHERE status IN ('queued', 'running') DO NOTHING`,
+		url.URL, payload)
+	return err
+}
+
+// ClaimJob takes the next job off the queue, skipping jobs other workers
+// hold. The job is hidden from other workers for visibilityTimeout, after
+// which it's assumed the worker died and the job is handed out again.
+// It returns nil when the queue is empty.
+func (p *Postgres) ClaimJob(ctx context.Context, visibilityTimeout time.Duration) (*Job, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var jobs []Job
+	err := p.db.SelectContext(ctx, &jobs, `
+	UPDATE jobs SET
+		status = 'running',
+		attempts = attempts + 1,
+		locked_until = now() + $1 * interval '1 second',
+		updated_at = now()
+	WHERE id = (
+		SELECT id FROM jobs
+			WHERE (status = 'queued' AND run_at <= now())
+			OR (status = 'running' AND locked_until < now() AND attempts < $2)
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *`,
+		visibilityTimeout.Seconds(), MaximumJobAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+// ExtendJob hides a running job from other workers for another
+// visibilityTimeout. attempt is the job's Attempts when it was claimed, so
+// that a worker that took too long can't extend a job that has since been
+// handed to another worker. It reports whether the job was extended.
+func (p *Postgres) ExtendJob(ctx context.Context, id int, attempt int, visibilityTimeout time.Duration) (bool, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	result, err := p.db.ExecContext(ctx, `
+	UPDATE jobs SET locked_until = now() + $1 * interval '1 second', updated_at = now()
+		WHERE id = $2 AND status = 'running' AND attempts = $3`,
+		visibilityTimeout.Seconds(), id, attempt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (p *Postgres) CompleteJob(ctx context.Context, id int) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	UPDATE jobs SET status = 'done', locked_until = NULL, last_error = '', updated_at = now()
+		WHERE id = $1`, id)
+	return err
+}
+
+func (p *Postgres) FailJob(ctx context.Context, id int, reason string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	UPDATE jobs SET status = 'failed', locked_until = NULL, last_error = $1, updated_at = now()
+		WHERE id = $2`, reason, id)
+	return err
+}
+
+// ReleaseJob puts a job that was interrupted back on the queue without
+// counting it as an attempt.
+func (p *Postgres) ReleaseJob(ctx context.Context, id int) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	UPDATE jobs SET status = 'queued', locked_until = NULL, run_at = now(),
+		attempts = GREATEST(attempts - 1, 0), updated_at = now()
+		WHERE id = $1 AND status = 'running'`, id)
+	return err
+}
+
+// RetryJob puts a job that failed for a reason that might go away back on
+// the queue to run again at runAt. Its attempts start again, since they
+// count workers dying rather than downloads failing.
+func (p *Postgres) RetryJob(ctx context.Context, id int, reason string, runAt time.Time) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	UPDATE jobs SET status = 'queued', locked_until = NULL, run_at = $1,
+		attempts = 0, last_error = $2, updated_at = now()
+		WHERE id = $3`, runAt, reason, id)
+	return err
+}
+
+// ReapJobs fails jobs that timed out too many times and deletes finished
+// jobs last updated before finishedBefore.
+func (p *Postgres) ReapJobs(ctx context.Context, finishedBefore time.Time) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	UPDATE jobs SET status = 'failed', locked_until = NULL, last_error = 'timed out', updated_at = now()
+		WHERE status = 'running' AND locked_until < now() AND attempts >= $1`,
+		MaximumJobAttempts)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `
+	DELETE FROM jobs WHERE status IN ('done', 'failed') AND updated_at < $1`,
+		finishedBefore)
+	return err
+}
+
+func (p *Postgres) GetJobCounts(ctx context.Context) ([]JobCount, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var counts []JobCount
+	err := p.db.SelectContext(ctx, &counts, `
+		SELECT status, COUNT(*) AS count FROM jobs
+			GROUP BY status
+			ORDER BY status`)
+	return counts, err
+}
+
+// GetJobs returns jobs with status, oldest first.
+func (p *Postgres) GetJobs(ctx context.Context, status string, page int, pageSize int) ([]Job, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var jobs []Job
+	err := p.db.SelectContext(ctx, &jobs, `
+		SELECT * FROM jobs
+			WHERE status = $1
+			ORDER BY updated_at
+			LIMIT $2 OFFSET $3`,
+		status, pageSize, (page-1)*pageSize)
+	return jobs, err
+}
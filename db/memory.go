@@ -0,0 +1,877 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps everything in memory, for tests that don't
+// want a live Postgres. It follows the same rules as Postgres closely
+// enough for handlers to be tested against it, but matches searches to
+// whole words in titles, without stemming.
+type Memory struct {
+	mutex           sync.Mutex
+	urls            []URL
+	views           []View
+	sources         []Source
+	downloadResults map[string]DownloadResult
+	jobs            []Job
+	nextID          int
+
+	// now is when the top listings are counted from, which tests can
+	// change.
+	now func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		now:             time.Now,
+		downloadResults: map[string]DownloadResult{},
+	}
+}
+
+func (m *Memory) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+func (f Filter) matches(url URL) bool {
+	if url.LinkFailures >= MaximumLinkFailures {
+		return false
+	}
+	if f.SubReddit != "" && !strings.EqualFold(url.SubReddit, f.SubReddit) {
+		return false
+	}
+	if f.Author != "" && !strings.EqualFold(url.Author, f.Author) {
+		return false
+	}
+	return true
+}
+
+func (m *Memory) filter(filter Filter, nsfw bool) []URL {
+	var urls []URL
+	for _, url := range m.urls {
+		if url.NSFW == nsfw && filter.matches(url) {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+func paginate(urls []URL, page int, pageSize int) []URL {
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * pageSize
+	if start < 0 || start >= len(urls) {
+		return nil
+	}
+	end := start + pageSize
+	if end > len(urls) {
+		end = len(urls)
+	}
+	return urls[start:end]
+}
+
+func (m *Memory) GetRandomURL(ctx context.Context, nsfw bool) (URL, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	urls := m.filter(Filter{}, nsfw)
+	if len(urls) == 0 {
+		return URL{}, sql.ErrNoRows
+	}
+	return urls[rand.Intn(len(urls))], nil
+}
+
+// orders sort urls the same way Postgres does for each listing.
+var orders = map[string]func(a URL, b URL) bool{
+	OrderNew: func(a URL, b URL) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	},
+	OrderTop: func(a URL, b URL) bool {
+		if a.Views != b.Views {
+			return a.Views > b.Views
+		}
+		return a.ID > b.ID
+	},
+	OrderTrending: func(a URL, b URL) bool {
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return a.ID > b.ID
+	},
+	OrderSearch: func(a URL, b URL) bool {
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return a.ID < b.ID
+	},
+}
+
+// shuffled sorts urls the same way as GetShuffledURLs, starting from the
+// first url whose random number is at least seed.
+func shuffled(seed float64) func(a URL, b URL) bool {
+	return func(a URL, b URL) bool {
+		if (a.Random < seed) != (b.Random < seed) {
+			return b.Random < seed
+		}
+		if a.Random != b.Random {
+			return a.Random < b.Random
+		}
+		return a.ID < b.ID
+	}
+}
+
+// listing sorts urls into order with less and picks out page.
+func listing(order string, less func(a URL, b URL) bool, urls []URL, page Page) ([]URL, *Cursor, error) {
+	after, err := page.after(order)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(urls, func(i, j int) bool { return less(urls[i], urls[j]) })
+
+	if after != nil {
+		last := URL{CreatedAt: after.CreatedAt, Views: after.Views, Rank: after.Rank, Random: after.Random, ID: after.ID}
+		var rest []URL
+		for _, url := range urls {
+			if less(last, url) {
+				rest = append(rest, url)
+			}
+		}
+		urls = paginate(rest, 1, page.Size)
+	} else {
+		urls = paginate(urls, page.Number, page.Size)
+	}
+	return urls, nextCursor(order, urls, page), nil
+}
+
+func (m *Memory) GetURLs(ctx context.Context, query string, filter Filter, nsfw bool, page Page) ([]URL, *Cursor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if query == "" {
+		return listing(OrderNew, orders[OrderNew], m.filter(filter, nsfw), page)
+	}
+
+	search := ParseSearch(query)
+	if len(search) == 0 {
+		return nil, nil, nil
+	}
+	var urls []URL
+	for _, url := range m.filter(filter, nsfw) {
+		if search.matches(url.Title) {
+			url.Rank = 1
+			urls = append(urls, url)
+		}
+	}
+	return listing(OrderSearch, orders[OrderSearch], urls, page)
+}
+
+func (m *Memory) GetTopURLs(ctx context.Context, filter Filter, nsfw bool, window string, page Page) ([]URL, *Cursor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !ValidTopWindow(window) {
+		return nil, nil, fmt.Errorf("invalid top window %q", window)
+	}
+	order := topOrder(window)
+	now := m.now()
+	if window == TopAll {
+		since, until := windowBounds(page, time.Time{}, now)
+		recent := map[int]int{}
+		for _, view := range m.views {
+			if !view.CreatedAt.Before(until) {
+				recent[view.URLID]++
+			}
+		}
+		var urls []URL
+		for _, url := range m.filter(filter, nsfw) {
+			url.Views -= recent[url.ID]
+			urls = append(urls, url)
+		}
+		urls, next, err := listing(order, orders[OrderTop], urls, page)
+		if next != nil {
+			next.Since = since
+			next.Until = until
+		}
+		return urls, next, err
+	}
+
+	since, until := windowBounds(page, windowStart(window, now), now)
+	counts := map[int]int{}
+	for _, view := range m.views {
+		if !view.CreatedAt.Before(since) && view.CreatedAt.Before(until) {
+			counts[view.URLID]++
+		}
+	}
+	var urls []URL
+	for _, url := range m.filter(filter, nsfw) {
+		if views := counts[url.ID]; views > 0 {
+			url.Views = views
+			urls = append(urls, url)
+		}
+	}
+	urls, next, err := listing(order, orders[OrderTop], urls, page)
+	if next != nil {
+		next.Since = since
+		next.Until = until
+	}
+	return urls, next, err
+}
+
+func (m *Memory) GetTrendingURLs(ctx context.Context, filter Filter, nsfw bool, page Page) ([]URL, *Cursor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	since, until := windowBounds(page, trendingStart(now), now)
+	ranks := map[int]float64{}
+	for _, view := range m.views {
+		if !view.CreatedAt.Before(since) && view.CreatedAt.Before(until) {
+			age := view.CreatedAt.Truncate(time.Hour).Sub(since)
+			ranks[view.URLID] += math.Pow(2, age.Seconds()/TrendingHalfLife.Seconds())
+		}
+	}
+	var urls []URL
+	for _, url := range m.filter(filter, nsfw) {
+		if rank := ranks[url.ID]; rank > 0 {
+			url.Rank = rank
+			urls = append(urls, url)
+		}
+	}
+	urls, next, err := listing(OrderTrending, orders[OrderTrending], urls, page)
+	if next != nil {
+		next.Since = since
+		next.Until = until
+	}
+	return urls, next, err
+}
+
+func (m *Memory) GetShuffledURLs(ctx context.Context, filter Filter, nsfw bool, seed float64, page Page) ([]URL, *Cursor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if page.After != nil {
+		seed = page.After.Seed
+	}
+	urls, next, err := listing(OrderShuffle, shuffled(seed), m.filter(filter, nsfw), page)
+	if next != nil {
+		next.Seed = seed
+	}
+	return urls, next, err
+}
+
+func (m *Memory) GetURL(ctx context.Context, id int) (*URL, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, url := range m.urls {
+		if url.ID == id {
+			return &url, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) GetURLCount(ctx context.Context) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.urls), nil
+}
+
+func (m *Memory) ExistsInDB(ctx context.Context, url URL) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, existing := range m.urls {
+		if existing.URL == url.URL || existing.SourceURL == url.SourceURL {
+			return existing.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *Memory) UpdateURL(ctx context.Context, id int, url URL) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, existing := range m.urls {
+		if existing.ID == id && existing.SourceURL == url.SourceURL {
+			existing.NSFW = url.NSFW
+			existing.RedditID = url.RedditID
+			existing.SubReddit = url.SubReddit
+			existing.Author = url.Author
+			existing.Score = url.Score
+			existing.NumComments = url.NumComments
+			existing.Flair = url.Flair
+			existing.Spoiler = url.Spoiler
+			m.urls[i] = existing
+		}
+	}
+	return nil
+}
+
+func (m *Memory) SaveURL(ctx context.Context, url *URL) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	saved := *url
+	saved.ID = m.id()
+	if saved.Random == 0 {
+		saved.Random = rand.Float64()
+	}
+	m.urls = append(m.urls, saved)
+	return nil
+}
+
+func (m *Memory) StoreURLViews(ctx context.Context, views []View) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, view := range views {
+		view.CreatedAt = view.CreatedAt.UTC()
+		m.views = append(m.views, view)
+		for i := range m.urls {
+			if m.urls[i].ID == view.URLID {
+				m.urls[i].Views++
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ReapViewCounts(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	before := m.now().Add(-bucketRetention[daily])
+	var views []View
+	for _, view := range m.views {
+		if !view.CreatedAt.Before(before) {
+			views = append(views, view)
+		}
+	}
+	m.views = views
+	return nil
+}
+
+func (m *Memory) GetSubRedditCounts(ctx context.Context, nsfw bool) ([]SubRedditCount, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counts := map[string]int{}
+	for _, url := range m.filter(Filter{}, nsfw) {
+		if url.SubReddit != "" {
+			counts[url.SubReddit]++
+		}
+	}
+	var result []SubRedditCount
+	for name, count := range counts {
+		result = append(result, SubRedditCount{Name: name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func (m *Memory) GetURLsToCheck(ctx context.Context, checkedBefore time.Time, failingCheckedBefore time.Time, limit int) ([]URL, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	failing := func(url URL) bool {
+		return url.LinkFailures > 0 && url.LinkFailures < MaximumLinkFailures
+	}
+	var urls []URL
+	for _, url := range m.urls {
+		if url.LinkCheckedAt == nil || url.LinkCheckedAt.Before(checkedBefore) ||
+			(failing(url) && url.LinkCheckedAt.Before(failingCheckedBefore)) {
+			urls = append(urls, url)
+		}
+	}
+	sort.SliceStable(urls, func(i, j int) bool {
+		if urls[i].LinkCheckedAt == nil || urls[j].LinkCheckedAt == nil {
+			return urls[i].LinkCheckedAt == nil && urls[j].LinkCheckedAt != nil
+		}
+		if failing(urls[i]) != failing(urls[j]) {
+			return failing(urls[i])
+		}
+		return urls[i].LinkCheckedAt.Before(*urls[j].LinkCheckedAt)
+	})
+	return paginate(urls, 1, limit), nil
+}
+
+func (m *Memory) StoreLinkCheck(ctx context.Context, id int, status int, outcome string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for i := range m.urls {
+		if m.urls[i].ID == id {
+			m.urls[i].LinkStatus = status
+			m.urls[i].LinkCheckedAt = &now
+			switch outcome {
+			case LinkWorking:
+				m.urls[i].LinkFailures = 0
+			case LinkBroken:
+				m.urls[i].LinkFailures++
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetBrokenURLs(ctx context.Context, page int, pageSize int) ([]URL, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var urls []URL
+	for _, url := range m.urls {
+		if url.LinkFailures >= MaximumLinkFailures {
+			urls = append(urls, url)
+		}
+	}
+	return paginate(urls, page, pageSize), nil
+}
+
+func (m *Memory) GetSources(ctx context.Context) ([]Source, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sources := append([]Source(nil), m.sources...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].Priority != sources[j].Priority {
+			return sources[i].Priority > sources[j].Priority
+		}
+		return sources[i].Name < sources[j].Name
+	})
+	return sources, nil
+}
+
+func (m *Memory) GetEnabledSources(ctx context.Context) ([]Source, error) {
+	all, _ := m.GetSources(ctx)
+	var sources []Source
+	for _, source := range all {
+		if source.Enabled {
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+func (m *Memory) source(name string) *Source {
+	for i := range m.sources {
+		if strings.EqualFold(m.sources[i].Name, name) {
+			return &m.sources[i]
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetSource(ctx context.Context, name string) (*Source, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if source := m.source(name); source != nil {
+		found := *source
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) AddSource(ctx context.Context, source *Source) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.source(source.Name) != nil {
+		return fmt.Errorf("source %q already exists", source.Name)
+	}
+	source.ID = m.id()
+	source.CreatedAt = time.Now()
+	m.sources = append(m.sources, *source)
+	return nil
+}
+
+func (m *Memory) updateSource(name string, update func(*Source)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if source := m.source(name); source != nil {
+		update(source)
+	}
+	return nil
+}
+
+func (m *Memory) SetSourceEnabled(ctx context.Context, name string, enabled bool) error {
+	return m.updateSource(name, func(source *Source) {
+		source.Enabled = enabled
+	})
+}
+
+func (m *Memory) SetSourceSort(ctx context.Context, name string, sort string, timeWindow string) error {
+	return m.updateSource(name, func(source *Source) {
+		source.Sort = sort
+		source.TimeWindow = timeWindow
+	})
+}
+
+func (m *Memory) RequestSourceBackfill(ctx context.Context, name string) error {
+	return m.updateSource(name, func(source *Source) {
+		source.BackfilledAt = nil
+	})
+}
+
+func (m *Memory) MarkSourceBackfilled(ctx context.Context, name string) error {
+	return m.updateSource(name, func(source *Source) {
+		now := time.Now()
+		source.BackfilledAt = &now
+	})
+}
+
+func (m *Memory) MarkSourceCrawled(ctx context.Context, name string) error {
+	return m.updateSource(name, func(source *Source) {
+		now := time.Now()
+		source.LastCrawledAt = &now
+	})
+}
+
+func (m *Memory) PurgeSource(ctx context.Context, name string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fromSource := func(sourceURL string) bool {
+		return strings.Contains(strings.ToLower(sourceURL), "/r/"+strings.ToLower(name)+"/")
+	}
+	var deleted int64
+	var urls []URL
+	for _, url := range m.urls {
+		if fromSource(url.SourceURL) {
+			deleted++
+			continue
+		}
+		urls = append(urls, url)
+	}
+	m.urls = urls
+
+	now := time.Now()
+	for i := range m.jobs {
+		job := &m.jobs[i]
+		if job.Status != JobQueued && job.Status != JobRunning {
+			continue
+		}
+		if url, err := job.DecodeURL(); err == nil && fromSource(url.SourceURL) {
+			job.Status = JobFailed
+			job.LockedUntil = nil
+			job.LastError = "source purged"
+			job.UpdatedAt = now
+		}
+	}
+
+	var sources []Source
+	for _, source := range m.sources {
+		if !strings.EqualFold(source.Name, name) {
+			sources = append(sources, source)
+		}
+	}
+	m.sources = sources
+	return deleted, nil
+}
+
+func (m *Memory) StoreDownloadSuccess(ctx context.Context, url string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	result, ok := m.downloadResults[url]
+	if !ok {
+		result = DownloadResult{CreatedAt: now, URL: url}
+	}
+	result.UpdatedAt = now
+	result.Success = true
+	result.Error = ""
+	result.Attempts++
+	result.Permanent = false
+	result.RetryAt = nil
+	m.downloadResults[url] = result
+	return nil
+}
+
+func (m *Memory) StoreDownloadFailure(ctx context.Context, url URL, reason string, permanent bool, backoff time.Duration) error {
+	payload, err := json.Marshal(url)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	result, ok := m.downloadResults[url.URL]
+	if !ok {
+		result = DownloadResult{CreatedAt: now, URL: url.URL}
+	}
+	result.Payload = payload
+	retryAt := now.Add(backoff * time.Duration(math.Pow(2, float64(result.Attempts))))
+	result.UpdatedAt = now
+	result.Success = false
+	result.Error = reason
+	result.Attempts++
+	result.Permanent = permanent || result.Attempts >= MaximumDownloadAttempts
+	result.RetryAt = &retryAt
+	m.downloadResults[url.URL] = result
+	return nil
+}
+
+func (m *Memory) GetDownloadResult(ctx context.Context, url string) (*DownloadResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if result, ok := m.downloadResults[url]; ok {
+		return &result, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) GetFailedDownloads(ctx context.Context, page int, pageSize int) ([]DownloadResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var results []DownloadResult
+	for _, result := range m.downloadResults {
+		if !result.Success {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].UpdatedAt.After(results[j].UpdatedAt) })
+
+	start := (page - 1) * pageSize
+	if start < 0 || start >= len(results) {
+		return nil, nil
+	}
+	if end := start + pageSize; end < len(results) {
+		return results[start:end], nil
+	}
+	return results[start:], nil
+}
+
+func (m *Memory) RequeueDownload(ctx context.Context, url string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result, ok := m.downloadResults[url]
+	if !ok || result.Success {
+		return false, nil
+	}
+	result.Permanent = false
+	result.Attempts = 0
+	result.RetryAt = nil
+	result.UpdatedAt = time.Now()
+	m.downloadResults[url] = result
+	if result.Payload != nil {
+		m.enqueue(url, result.Payload)
+	}
+	return true, nil
+}
+
+func (m *Memory) EnqueueURL(ctx context.Context, url URL) error {
+	payload, err := json.Marshal(url)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.enqueue(url.URL, payload)
+	return nil
+}
+
+// enqueue adds a job for url unless there's one waiting already. The
+// mutex has to be held.
+func (m *Memory) enqueue(url string, payload []byte) {
+	for _, job := range m.jobs {
+		if job.URL == url && (job.Status == JobQueued || job.Status == JobRunning) {
+			return
+		}
+	}
+	now := time.Now()
+	m.jobs = append(m.jobs, Job{
+		ID:        m.id(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		URL:       url,
+		Payload:   payload,
+		Status:    JobQueued,
+		RunAt:     now,
+	})
+}
+
+func (m *Memory) ClaimJob(ctx context.Context, visibilityTimeout time.Duration) (*Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for i := range m.jobs {
+		job := &m.jobs[i]
+		queued := job.Status == JobQueued && !job.RunAt.After(now)
+		timedOut := job.Status == JobRunning && job.LockedUntil.Before(now) && job.Attempts < MaximumJobAttempts
+		if queued || timedOut {
+			lockedUntil := now.Add(visibilityTimeout)
+			job.Status = JobRunning
+			job.Attempts++
+			job.LockedUntil = &lockedUntil
+			job.UpdatedAt = now
+			claimed := *job
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) ExtendJob(ctx context.Context, id int, attempt int, visibilityTimeout time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.jobs {
+		job := &m.jobs[i]
+		if job.ID == id && job.Status == JobRunning && job.Attempts == attempt {
+			now := time.Now()
+			lockedUntil := now.Add(visibilityTimeout)
+			job.LockedUntil = &lockedUntil
+			job.UpdatedAt = now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) updateJob(id int, update func(*Job)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.jobs {
+		if m.jobs[i].ID == id {
+			update(&m.jobs[i])
+			m.jobs[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return errors.New("no such job")
+}
+
+func (m *Memory) CompleteJob(ctx context.Context, id int) error {
+	return m.updateJob(id, func(job *Job) {
+		job.Status = JobDone
+		job.LockedUntil = nil
+		job.LastError = ""
+	})
+}
+
+func (m *Memory) FailJob(ctx context.Context, id int, reason string) error {
+	return m.updateJob(id, func(job *Job) {
+		job.Status = JobFailed
+		job.LockedUntil = nil
+		job.LastError = reason
+	})
+}
+
+func (m *Memory) ReleaseJob(ctx context.Context, id int) error {
+	return m.updateJob(id, func(job *Job) {
+		if job.Status != JobRunning {
+			return
+		}
+		job.Status = JobQueued
+		job.LockedUntil = nil
+		job.RunAt = time.Now()
+		if job.Attempts > 0 {
+			job.Attempts--
+		}
+	})
+}
+
+func (m *Memory) RetryJob(ctx context.Context, id int, reason string, runAt time.Time) error {
+	return m.updateJob(id, func(job *Job) {
+		job.Status = JobQueued
+		job.LockedUntil = nil
+		job.RunAt = runAt
+		job.Attempts = 0
+		job.LastError = reason
+	})
+}
+
+func (m *Memory) ReapJobs(ctx context.Context, finishedBefore time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	var jobs []Job
+	for _, job := range m.jobs {
+		if job.Status == JobRunning && job.LockedUntil.Before(now) && job.Attempts >= MaximumJobAttempts {
+			job.Status = JobFailed
+			job.LockedUntil = nil
+			job.LastError = "timed out"
+			job.UpdatedAt = now
+		}
+		finished := job.Status == JobDone || job.Status == JobFailed
+		if finished && job.UpdatedAt.Before(finishedBefore) {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	m.jobs = jobs
+	return nil
+}
+
+func (m *Memory) GetJobCounts(ctx context.Context) ([]JobCount, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counts := map[string]int{}
+	for _, job := range m.jobs {
+		counts[job.Status]++
+	}
+	var result []JobCount
+	for status, count := range counts {
+		result = append(result, JobCount{Status: status, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Status < result[j].Status })
+	return result, nil
+}
+
+func (m *Memory) GetJobs(ctx context.Context, status string, page int, pageSize int) ([]Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var jobs []Job
+	for _, job := range m.jobs {
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].UpdatedAt.Before(jobs[j].UpdatedAt) })
+
+	start := (page - 1) * pageSize
+	if start < 0 || start >= len(jobs) {
+		return nil, nil
+	}
+	if end := start + pageSize; end < len(jobs) {
+		return jobs[start:end], nil
+	}
+	return jobs[start:], nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Only letters and numbers make it into a tsquery, so nothing anybody
+// searches for can be taken for tsquery syntax.
+var searchWords = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// SearchTerm is a word, or a phrase of words that have to appear next to
+// each other, to search for.
+type SearchTerm struct {
+	Words []string
+	// Prefix matches the last word as the start of a word, while typing.
+	Prefix bool
+	// Exclude matches titles that don't contain the term.
+	Exclude bool
+}
+
+// SearchQuery is a parsed search. Titles match when they match every
+// group, and a group matches when any of its terms do.
+type SearchQuery [][]SearchTerm
+
+// ParseSearch parses a search like
+//
+//	"dancing dog" cat OR kitt* -hat
+//
+// where quotes make a phrase, OR between terms matches either, a leading
+// - excludes a term and a trailing * matches the start of a word. Every
+// other term has to match. Anything that isn't a letter or a number
+// separates words.
+func ParseSearch(search string) SearchQuery {
+	var query SearchQuery
+	or := false
+
+	runes := []rune(search)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var term SearchTerm
+		if runes[i] == '-' {
+			term.Exclude = true
+			i++
+		}
+
+		start := i
+		var text string
+		if i < len(runes) && runes[i] == '"' {
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			text = string(runes[start+1 : i])
+			if i < len(runes) {
+				i++
+			}
+			if i < len(runes) && runes[i] == '*' {
+				text += "*"
+				i++
+			}
+		} else {
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			text = string(runes[start:i])
+			if text == "OR" && !term.Exclude {
+				or = len(query) > 0
+				continue
+			}
+		}
+
+		term.Prefix = strings.HasSuffix(strings.TrimSpace(text), "*")
+		term.Words = searchWords.FindAllString(strings.ToLower(text), -1)
+		if len(term.Words) == 0 {
+			continue
+		}
+		if or {
+			query[len(query)-1] = append(query[len(query)-1], term)
+		} else {
+			query = append(query, []SearchTerm{term})
+		}
+		or = false
+	}
+	return query
+}
+
+// TSQuery compiles q for to_tsquery. It's empty when q is.
+func (q SearchQuery) TSQuery() string {
+	var groups []string
+	for _, group := range q {
+		var terms []string
+		for _, term := range group {
+			terms = append(terms, term.tsquery())
+		}
+		if len(terms) == 1 {
+			groups = append(groups, terms[0])
+		} else {
+			groups = append(groups, "("+strings.Join(terms, " | ")+")")
+		}
+	}
+	return strings.Join(groups, " & ")
+}
+
+func (t SearchTerm) tsquery() string {
+	words := append([]string{}, t.Words...)
+	if t.Prefix {
+		words[len(words)-1] += ":*"
+	}
+	s := strings.Join(words, " <-> ")
+	if len(words) > 1 {
+		s = "(" + s + ")"
+	}
+	if t.Exclude {
+		s = "!" + s
+	}
+	return s
+}
+
+// matches reports whether title matches q, for Memory. Words have to
+// match exactly, since there's no stemming.
+func (q SearchQuery) matches(title string) bool {
+	words := searchWords.FindAllString(strings.ToLower(title), -1)
+	for _, group := range q {
+		matched := false
+		for _, term := range group {
+			if term.matches(words) != term.Exclude {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (t SearchTerm) matches(words []string) bool {
+	for start := 0; start+len(t.Words) <= len(words); start++ {
+		matched := true
+		for i, word := range t.Words {
+			last := i == len(t.Words)-1
+			if words[start+i] != word && !(last && t.Prefix && strings.HasPrefix(words[start+i], word)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package db
+
+import "testing"
+
+func TestSearchQueryTSQuery(t *testing.T) {
+	examples := []struct {
+		search   string
+		expected string
+	}{
+		{"", ""},
+		{"dancing dog", "dancing & dog"},
+		{"Dancing DOG", "dancing & dog"},
+		{`"dancing dog"`, "(dancing <-> dog)"},
+		{`"dancing dog`, "(dancing <-> dog)"},
+		{"cat -hat", "cat & !hat"},
+		{`cat -"top hat"`, "cat & !(top <-> hat)"},
+		{"cat OR dog", "(cat | dog)"},
+		{"cat OR dog OR -fish bird", "(cat | dog | !fish) & bird"},
+		{"OR cat OR", "cat"},
+		{"cat or dog", "cat & or & dog"},
+		{"kitt*", "kitt:*"},
+		{`"dancing do"*`, "(dancing <-> do:*)"},
+		{"spider-man", "(spider <-> man)"},
+		{"café", "café"},
+		{`it's a ' & | ! <-> :* () trap`, "(it <-> s) & a & trap"},
+		{"- * \"\"", ""},
+	}
+
+	for _, example := range examples {
+		actual := ParseSearch(example.search).TSQuery()
+		if actual != example.expected {
+			t.Errorf("Expected %q to compile to:\n%v\nGot:\n%v\n", example.search, example.expected, actual)
+		}
+	}
+}
+
+func TestSearchQueryMatches(t *testing.T) {
+	examples := []struct {
+		search   string
+		title    string
+		expected bool
+	}{
+		{"dog", "Dancing dog", true},
+		{"dog", "Dancing doggo", false},
+		{"dog*", "Dancing doggo", true},
+		{`"dancing dog"`, "Dancing dog", true},
+		{`"dog dancing"`, "Dancing dog", false},
+		{"dog -cat", "Dancing dog", true},
+		{"dog -cat", "Dancing dog and cat", false},
+		{"cat OR dog", "Dancing dog", true},
+		{"cat OR fish", "Dancing dog", false},
+	}
+
+	for _, example := range examples {
+		actual := ParseSearch(example.search).matches(example.title)
+		if actual != example.expected {
+			t.Errorf("Expected %q to match %q:\n%v\nGot:\n%v\n", example.search, example.title, example.expected, actual)
+		}
+	}
+}
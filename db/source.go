@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type Source struct {
+	ID            int        `db:"id"`
+	CreatedAt     time.Time  `db:"created_at"`
+	Name          string     `db:"name"`
+	NSFW          bool       `db:"nsfw"`
+	Enabled       bool       `db:"enabled"`
+	Priority      int        `db:"priority"`
+	CrawlInterval int        `db:"crawl_interval"`
+	Sort          string     `db:"sort"`
+	TimeWindow    string     `db:"time_window"`
+	BackfilledAt  *time.Time `db:"backfilled_at"`
+	LastCrawledAt *time.Time `db:"last_crawled_at"`
+}
+
+func (p *Postgres) GetSources(ctx context.Context) ([]Source, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var sources []Source
+	err := p.db.SelectContext(ctx, &sources, `SELECT * FROM sources ORDER BY priority DESC, name`)
+	return sources, err
+}
+
+func (p *Postgres) GetEnabledSources(ctx context.Context) ([]Source, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var sources []Source
+	err := p.db.SelectContext(ctx, &sources, `
+		SELECT * FROM sources
+			WHERE enabled = true
+			ORDER BY priority DESC, last_crawled_at ASC NULLS FIRST`)
+	return sources, err
+}
+
+func (p *Postgres) GetSource(ctx context.Context, name string) (*Source, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var sources []Source
+	err := p.db.SelectContext(ctx, &sources, `SELECT * FROM sources WHERE lower(name) = lower($1) LIMIT 1`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) > 0 {
+		return &sources[0], nil
+	}
+	return nil, nil
+}
+
+func (p *Postgres) AddSource(ctx context.Context, source *Source) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	return p.db.GetContext(ctx, source, `
+	INSERT INTO sources (name, nsfw, enabled, priority, crawl_interval, sort, time_window)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING *`,
+		source.Name,
+		source.NSFW,
+		source.Enabled,
+		source.Priority,
+		source.CrawlInterval,
+		source.Sort,
+		source.TimeWindow,
+	)
+}
+
+func (p *Postgres) SetSourceEnabled(ctx context.Context, name string, enabled bool) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `UPDATE sources SET enabled = $1 WHERE lower(name) = lower($2)`, enabled, name)
+	return err
+}
+
+func (p *Postgres) SetSourceSort(ctx context.Context, name string, sort string, timeWindow string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `UPDATE sources SET sort = $1, time_window = $2 WHERE lower(name) = lower($3)`, sort, timeWindow, name)
+	return err
+}
+
+// RequestSourceBackfill makes the next crawl of a source walk its all time
+// top listing instead of its usual sort.
+func (p *Postgres) RequestSourceBackfill(ctx context.Context, name string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `UPDATE sources SET backfilled_at = NULL WHERE lower(name) = lower($1)`, name)
+	return err
+}
+
+func (p *Postgres) MarkSourceBackfilled(ctx context.Context, name string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `UPDATE sources SET backfilled_at = now() WHERE lower(name) = lower($1)`, name)
+	return err
+}
+
+func (p *Postgres) MarkSourceCrawled(ctx context.Context, name string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `UPDATE sources SET last_crawled_at = now() WHERE lower(name) = lower($1)`, name)
+	return err
+}
+
+// PurgeSource deletes a source along with every url that was ingested from
+// it, returning the number of urls deleted. Its jobs that haven't finished
+// are failed so that workers don't save its urls again.
+func (p *Postgres) PurgeSource(ctx context.Context, name string) (int64, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	pattern := "%/r/" + escapeLike(name) + "/%"
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM url_views WHERE url_id IN (
+		SELECT id FROM urls WHERE source_url ILIKE $1
+	)`, pattern)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM url_view_counts WHERE url_id IN (
+		SELECT id FROM urls WHERE source_url ILIKE $1
+	)`, pattern)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM urls WHERE source_url ILIKE $1`, pattern)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+	UPDATE jobs SET status = 'failed', locked_until = NULL, last_error = 'source purged', updated_at = now()
+		WHERE status IN ('queued', 'running') AND payload->>'SourceURL' ILIKE $1`, pattern)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM sources WHERE lower(name) = lower($1)`, name)
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+func escapeLike(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `%`, `\%`, -1)
+	return strings.Replace(s, `_`, `\_`, -1)
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryPurgeSourceFailsPendingJobs(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	store.AddSource(ctx, &Source{Name: "gifs"})
+	store.EnqueueURL(ctx, URL{URL: "http://example.com/1.gif", SourceURL: "https://www.reddit.com/r/gifs/comments/1/"})
+	store.EnqueueURL(ctx, URL{URL: "http://example.com/2.gif", SourceURL: "https://www.reddit.com/r/Gifs/comments/2/"})
+	store.EnqueueURL(ctx, URL{URL: "http://example.com/3.gif", SourceURL: "https://www.reddit.com/r/cats/comments/3/"})
+
+	running, err := store.ClaimJob(ctx, time.Minute)
+	if err != nil || running == nil {
+		t.Fatalf("Expected a job to be claimed\nGot:\n%v %v\n", running, err)
+	}
+	if _, err := store.PurgeSource(ctx, "gifs"); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, _ := store.GetJobs(ctx, JobFailed, 1, 10)
+	if len(failed) != 2 {
+		t.Errorf("Expected both gifs jobs to be failed\nGot:\n%+v\n", failed)
+	}
+	if held, _ := store.ExtendJob(ctx, running.ID, running.Attempts, time.Minute); held {
+		t.Errorf("Expected the running job to be taken from its worker")
+	}
+	job, _ := store.ClaimJob(ctx, time.Minute)
+	if job == nil || job.URL != "http://example.com/3.gif" {
+		t.Errorf("Expected:\n%v\nGot:\n%+v\n", "http://example.com/3.gif", job)
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store is everything the site keeps in the database. Postgres is the real
+// thing and Memory stands in for it in tests. Queries give up when their
+// ctx is cancelled.
+type Store interface {
+	GetRandomURL(ctx context.Context, nsfw bool) (URL, error)
+	GetURLs(ctx context.Context, query string, filter Filter, nsfw bool, page Page) ([]URL, *Cursor, error)
+	GetTopURLs(ctx context.Context, filter Filter, nsfw bool, window string, page Page) ([]URL, *Cursor, error)
+	GetTrendingURLs(ctx context.Context, filter Filter, nsfw bool, page Page) ([]URL, *Cursor, error)
+	GetShuffledURLs(ctx context.Context, filter Filter, nsfw bool, seed float64, page Page) ([]URL, *Cursor, error)
+	GetURL(ctx context.Context, id int) (*URL, error)
+	GetURLCount(ctx context.Context) (int, error)
+	ExistsInDB(ctx context.Context, url URL) (int, error)
+	UpdateURL(ctx context.Context, id int, url URL) error
+	SaveURL(ctx context.Context, url *URL) error
+	StoreURLViews(ctx context.Context, views []View) error
+	ReapViewCounts(ctx context.Context) error
+	GetSubRedditCounts(ctx context.Context, nsfw bool) ([]SubRedditCount, error)
+	GetURLsToCheck(ctx context.Context, checkedBefore time.Time, failingCheckedBefore time.Time, limit int) ([]URL, error)
+	StoreLinkCheck(ctx context.Context, id int, status int, outcome string) error
+	GetBrokenURLs(ctx context.Context, page int, pageSize int) ([]URL, error)
+
+	GetSources(ctx context.Context) ([]Source, error)
+	GetEnabledSources(ctx context.Context) ([]Source, error)
+	GetSource(ctx context.Context, name string) (*Source, error)
+	AddSource(ctx context.Context, source *Source) error
+	SetSourceEnabled(ctx context.Context, name string, enabled bool) error
+	SetSourceSort(ctx context.Context, name string, sort string, timeWindow string) error
+	RequestSourceBackfill(ctx context.Context, name string) error
+	MarkSourceBackfilled(ctx context.Context, name string) error
+	MarkSourceCrawled(ctx context.Context, name string) error
+	PurgeSource(ctx context.Context, name string) (int64, error)
+
+	StoreDownloadSuccess(ctx context.Context, url string) error
+	StoreDownloadFailure(ctx context.Context, url URL, reason string, permanent bool, backoff time.Duration) error
+	GetDownloadResult(ctx context.Context, url string) (*DownloadResult, error)
+	GetFailedDownloads(ctx context.Context, page int, pageSize int) ([]DownloadResult, error)
+	RequeueDownload(ctx context.Context, url string) (bool, error)
+
+	EnqueueURL(ctx context.Context, url URL) error
+	ClaimJob(ctx context.Context, visibilityTimeout time.Duration) (*Job, error)
+	ExtendJob(ctx context.Context, id int, attempt int, visibilityTimeout time.Duration) (bool, error)
+	CompleteJob(ctx context.Context, id int) error
+	FailJob(ctx context.Context, id int, reason string) error
+	ReleaseJob(ctx context.Context, id int) error
+	RetryJob(ctx context.Context, id int, reason string, runAt time.Time) error
+	ReapJobs(ctx context.Context, finishedBefore time.Time) error
+	GetJobCounts(ctx context.Context) ([]JobCount, error)
+	GetJobs(ctx context.Context, status string, page int, pageSize int) ([]Job, error)
+}
+
+var (
+	_ Store = &Postgres{}
+	_ Store = &Memory{}
+)
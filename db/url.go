@@ -1,9 +1,12 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"regexp"
+	"math/rand"
+	"path"
 	"strings"
 	"time"
 
@@ -24,11 +27,27 @@ type URL struct {
 	Height       int       `db:"height"`
 	NSFW         bool      `db:"nsfw"`
 	Views        int       `db:"views"`
+	RedditID     string    `db:"reddit_id"`
+	SubReddit    string    `db:"subreddit"`
+	Author       string    `db:"author"`
+	Score        int       `db:"score"`
+	NumComments  int       `db:"num_comments"`
+	Flair        string    `db:"flair"`
+	Spoiler      bool      `db:"spoiler"`
 
-	// never used, just here to appease sqlx
-	TSV    string  `db:"tsv" json:"-"`
-	Query  string  `db:"query" json:"-"`
+	LinkStatus    int        `db:"link_status"`
+	LinkFailures  int        `db:"link_failures"`
+	LinkCheckedAt *time.Time `db:"link_checked_at"`
+
+	// Rank is how well a url matched a search.
+	Rank float64 `db:"rank" json:"-"`
+
+	// Random is fixed when a url is saved, and is what it's shuffled by.
 	Random float64 `db:"random" json:"-"`
+
+	// never used, just here to appease sqlx
+	TSV   string `db:"tsv" json:"-"`
+	Query string `db:"query" json:"-"`
 }
 
 func (u URL) ToJSON() (string, error) {
@@ -48,63 +67,172 @@ func (u URL) HumanCreatedAt() string {
 	return humanize.Time(u.CreatedAt)
 }
 
+// IsVideo reports whether u came from its source as video rather than as
+// a gif, in which case URL is the video.
+func (u URL) IsVideo() bool {
+	switch strings.ToLower(path.Ext(strings.SplitN(u.URL, "?", 2)[0])) {
+	case ".mp4", ".webm":
+		return true
+	}
+	return false
+}
+
+// ImageURL is an image of u for places that can't show video. It's the
+// thumbnail when u came as video, which it may not have.
+func (u URL) ImageURL() string {
+	if u.IsVideo() {
+		return u.ThumbnailURL
+	}
+	return u.URL
+}
+
 func (u URL) ShareMarkdown() string {
-	return fmt.Sprintf("![%s](%s)", u.URL, u.URL)
+	if !u.IsVideo() {
+		return fmt.Sprintf("![%s](%s)", u.URL, u.URL)
+	}
+	if u.ThumbnailURL == "" {
+		return fmt.Sprintf("[%s](%s)", u.URL, u.URL)
+	}
+	return fmt.Sprintf("[![%s](%s)](%s)", u.URL, u.ThumbnailURL, u.URL)
+}
+
+func (u URL) SubRedditPath() string {
+	return u.listingPath("source", u.SubReddit)
+}
+
+func (u URL) AuthorPath() string {
+	return u.listingPath("author", u.Author)
 }
 
-func GetRandomURL(nsfw bool) (URL, error) {
-	db, err := db()
+func (u URL) listingPath(kind string, name string) string {
+	if u.NSFW {
+		return fmt.Sprintf("/nsfw/%v/%v", kind, name)
+	}
+	return fmt.Sprintf("/%v/%v", kind, name)
+}
+
+// MaximumLinkFailures is how many link checks in a row a url can fail
+// before it's hidden from listings.
+const MaximumLinkFailures = 3
+
+// What checking a url's links found. Inconclusive checks, like timeouts
+// and server errors, don't count towards hiding a url, so that a host
+// being down for a while doesn't hide everything on it.
+const (
+	LinkWorking      = "working"
+	LinkBroken       = "broken"
+	LinkInconclusive = "inconclusive"
+)
+
+var working = fmt.Sprintf("urls.link_failures < %d", MaximumLinkFailures)
+
+// GetRandomURL picks the url whose random number comes after a random
+// point, which unlike ORDER BY random() doesn't have to look at every url.
+func (p *Postgres) GetRandomURL(ctx context.Context, nsfw bool) (URL, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var urls []URL
+	err := p.db.SelectContext(ctx, &urls, `
+		(SELECT * FROM urls WHERE nsfw = $1 AND random >= $2 AND `+working+` ORDER BY random LIMIT 1)
+		UNION ALL
+		(SELECT * FROM urls WHERE nsfw = $1 AND `+working+` ORDER BY random LIMIT 1)`,
+		nsfw, rand.Float64())
 	if err != nil {
 		return URL{}, err
 	}
-	var url URL
-	err = db.Get(&url, "SELECT * FROM urls WHERE nsfw=$1 ORDER BY random() LIMIT 1", nsfw)
-	return url, err
+	if len(urls) == 0 {
+		return URL{}, sql.ErrNoRows
+	}
+	return urls[0], nil
 }
 
-func GetURLs(query string, nsfw bool, page int, pageSize int) ([]URL, error) {
-	db, err := db()
-	if err != nil {
-		return nil, err
+// Filter narrows a listing down to the gifs from one subreddit or author.
+type Filter struct {
+	SubReddit string
+	Author    string
+}
+
+// conditions returns sql to append to a WHERE clause, numbering its
+// placeholders after the args that are already in use. Urls with broken
+// links are always filtered out.
+func (f Filter) conditions(args []interface{}) (string, []interface{}) {
+	sql := " AND " + working
+	if f.SubReddit != "" {
+		args = append(args, f.SubReddit)
+		sql += fmt.Sprintf(" AND lower(urls.subreddit) = lower($%d)", len(args))
+	}
+	if f.Author != "" {
+		args = append(args, f.Author)
+		sql += fmt.Sprintf(" AND lower(urls.author) = lower($%d)", len(args))
 	}
+	return sql, args
+}
+
+func (p *Postgres) GetURLs(ctx context.Context, query string, filter Filter, nsfw bool, page Page) ([]URL, *Cursor, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
 
 	var urls []URL
 
 	if query != "" {
-		wordFinder := regexp.MustCompile("\\w+")
-		queryParts := wordFinder.FindAllString(query, -1)
-		tSearchQuery := strings.Join(queryParts, "&")
+		after, err := page.after(OrderSearch)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tsquery := ParseSearch(query).TSQuery()
+		if tsquery == "" {
+			return nil, nil, nil
+		}
 
-		err = db.Select(&urls, `
-	SELECT * FROM urls,
+		conditions, args := filter.conditions([]interface{}{tsquery, nsfw})
+		if after != nil {
+			args = append(args, after.Rank, after.ID)
+			conditions += fmt.Sprintf(`
+		AND (ts_rank_cd(tsv, query)::float8 < $%d
+			OR (ts_rank_cd(tsv, query)::float8 = $%d AND urls.id > $%d))`,
+				len(args)-1, len(args)-1, len(args))
+		}
+		limit, args := page.limit(args)
+		err = p.db.SelectContext(ctx, &urls, `
+	SELECT urls.*, ts_rank_cd(tsv, query)::float8 AS rank FROM urls,
 		to_tsquery('pg_catalog.english', $1) AS query
-		WHERE nsfw=$2
+		WHERE nsfw=$2`+conditions+`
 		AND (tsv @@ query)
 		ORDER BY
-			ts_rank_cd(tsv, query) DESC,
+			rank DESC,
 			id
-		LIMIT $3 OFFSET $4`,
-			tSearchQuery, nsfw, pageSize, (page-1)*pageSize)
-	} else {
-		err = db.Select(&urls, `
-	SELECT * FROM urls
-		WHERE nsfw = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3`,
-			nsfw, pageSize, (page-1)*pageSize)
+		`+limit,
+			args...)
+		return urls, nextCursor(OrderSearch, urls, page), err
 	}
 
-	return urls, err
-}
-
-func ExistsInDB(url URL) (int, error) {
-	db, err := db()
+	after, err := page.after(OrderNew)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
+	}
+	conditions, args := filter.conditions([]interface{}{nsfw})
+	if after != nil {
+		args = append(args, after.CreatedAt, after.ID)
+		conditions += fmt.Sprintf(" AND (urls.created_at, urls.id) < ($%d, $%d)", len(args)-1, len(args))
 	}
+	limit, args := page.limit(args)
+	err = p.db.SelectContext(ctx, &urls, `
+	SELECT * FROM urls
+		WHERE nsfw = $1`+conditions+`
+		ORDER BY created_at DESC, id DESC
+		`+limit,
+		args...)
+	return urls, nextCursor(OrderNew, urls, page), err
+}
+
+func (p *Postgres) ExistsInDB(ctx context.Context, url URL) (int, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
 
 	var ids []int
-	err = db.Select(&ids, "SELECT id FROM urls WHERE url = $1 OR source_url = $2 LIMIT 1;", url.URL, url.SourceURL)
+	err := p.db.SelectContext(ctx, &ids, "SELECT id FROM urls WHERE url = $1 OR source_url = $2 LIMIT 1;", url.URL, url.SourceURL)
 
 	if err != nil {
 		return 0, err
@@ -116,29 +244,43 @@ func ExistsInDB(url URL) (int, error) {
 	return 0, nil
 }
 
-func UpdateURL(id int, url URL) error {
-	db, err := db()
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(`UPDATE urls SET nsfw = $1 WHERE id = $2`,
+// UpdateURL refreshes the reddit metadata of the url with id from url, as
+// long as it's the same post. ExistsInDB also matches crossposts and
+// reposts of the same gif, which mustn't take over the original.
+func (p *Postgres) UpdateURL(ctx context.Context, id int, url URL) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+	UPDATE urls SET
+		nsfw = $1, reddit_id = $2, subreddit = $3, author = $4,
+		score = $5, num_comments = $6, flair = $7, spoiler = $8
+	WHERE id = $9 AND source_url = $10`,
 		url.NSFW,
+		url.RedditID,
+		url.SubReddit,
+		url.Author,
+		url.Score,
+		url.NumComments,
+		url.Flair,
+		url.Spoiler,
 		id,
+		url.SourceURL,
 	)
 	return err
 }
 
-func SaveURL(url *URL) error {
-	db, err := db()
-	if err != nil {
-		return err
-	}
+func (p *Postgres) SaveURL(ctx context.Context, url *URL) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
 
-	_, err = db.Exec(`
+	_, err := p.db.ExecContext(ctx, `
 	INSERT INTO urls (
-		created_at, title, nsfw, url, source_url, webmurl, mp4url, thumbnail_url, width, height
+		created_at, title, nsfw, url, source_url, webmurl, mp4url, thumbnail_url, width, height,
+		reddit_id, subreddit, author, score, num_comments, flair, spoiler
 	) VALUES (
-		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+		$11, $12, $13, $14, $15, $16, $17
 	)`,
 		url.CreatedAt,
 		url.Title,
@@ -150,6 +292,13 @@ func SaveURL(url *URL) error {
 		url.ThumbnailURL,
 		url.Width,
 		url.Height,
+		url.RedditID,
+		url.SubReddit,
+		url.Author,
+		url.Score,
+		url.NumComments,
+		url.Flair,
+		url.Spoiler,
 	)
 	if err != nil {
 		return err
@@ -157,35 +306,12 @@ func SaveURL(url *URL) error {
 	return nil
 }
 
-func GetTopURLs(nsfw bool, page int, pageSize int) ([]URL, error) {
-	db, err := db()
-	if err != nil {
-		return nil, err
-	}
-
-	var urls []URL
-	err = db.Select(&urls, `
-		SELECT urls.*,
-			COUNT(url_views.created_at) AS views
-			FROM urls
-			INNER JOIN url_views on url_views.url_id = urls.id
-			WHERE nsfw = $1
-			GROUP BY urls.id
-			ORDER BY views DESC
-			LIMIT $2 OFFSET $3
-		`,
-		nsfw, pageSize, (page-1)*pageSize)
-
-	return urls, err
-}
+func (p *Postgres) GetURL(ctx context.Context, id int) (*URL, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
 
-func GetURL(id int) (*URL, error) {
-	db, err := db()
-	if err != nil {
-		return nil, err
-	}
 	var urls []URL
-	err = db.Select(&urls, `SELECT * FROM urls WHERE id = $1 LIMIT 1`, id)
+	err := p.db.SelectContext(ctx, &urls, `SELECT * FROM urls WHERE id = $1 LIMIT 1`, id)
 	if err != nil {
 		return nil, err
 	}
@@ -195,38 +321,163 @@ func GetURL(id int) (*URL, error) {
 	return nil, err
 }
 
-func GetURLCount() (int, error) {
-	db, err := db()
-	if err != nil {
-		return 0, err
-	}
+func (p *Postgres) GetURLCount(ctx context.Context) (int, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
 	var count int
-	err = db.Get(&count, "SELECT COUNT(*) FROM urls")
+	err := p.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM urls")
 	return count, err
 }
 
-func GetShuffledURLs(nsfw bool, page int, pageSize int) ([]URL, error) {
-	db, err := db()
+// GetShuffledURLs pages through urls shuffled by seed, which is between 0
+// and 1. Every url has a fixed random number, and the shuffle starts from
+// the first url whose number is at least seed, wrapping around to the
+// smallest after the largest. That way a seed always gives the same order
+// and pages can be found with an index instead of sorting the whole table.
+func (p *Postgres) GetShuffledURLs(ctx context.Context, filter Filter, nsfw bool, seed float64, page Page) ([]URL, *Cursor, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	after, err := page.after(OrderShuffle)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var urls []URL
-	err = db.Select(&urls, `
+	if after == nil && page.Number > 1 {
+		conditions, args := filter.conditions([]interface{}{nsfw, seed})
+		limit, args := page.limit(args)
+		err = p.db.SelectContext(ctx, &urls, `
 		SELECT * FROM urls
-			WHERE nsfw = $1
-			ORDER BY random(), id
-			LIMIT $2 OFFSET $3`,
-		nsfw, pageSize, (page-1)*pageSize)
+			WHERE nsfw = $1`+conditions+`
+			ORDER BY urls.random < $2, urls.random, urls.id
+			`+limit,
+			args...)
+		return urls, shuffleCursor(urls, seed, page), err
+	}
+
+	if after == nil {
+		after = &Cursor{Random: seed}
+	} else {
+		seed = after.Seed
+	}
+
+	if after.Random >= seed {
+		urls, err = p.shuffleSegment(ctx, filter, nsfw, after.Random, after.ID, 1, page.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		after = &Cursor{Random: -1}
+	}
+	if len(urls) < page.Size {
+		wrapped, err := p.shuffleSegment(ctx, filter, nsfw, after.Random, after.ID, seed, page.Size-len(urls))
+		if err != nil {
+			return nil, nil, err
+		}
+		urls = append(urls, wrapped...)
+	}
+	return urls, shuffleCursor(urls, seed, page), nil
+}
 
+// shuffleSegment returns up to limit urls whose random numbers come after
+// random and id and are less than below, in order.
+func (p *Postgres) shuffleSegment(ctx context.Context, filter Filter, nsfw bool, random float64, id int, below float64, limit int) ([]URL, error) {
+	conditions, args := filter.conditions([]interface{}{nsfw, random, id, below, limit})
+	var urls []URL
+	err := p.db.SelectContext(ctx, &urls, `
+		SELECT * FROM urls
+			WHERE nsfw = $1
+			AND (urls.random, urls.id) > ($2, $3)
+			AND urls.random < $4`+conditions+`
+			ORDER BY random, id
+			LIMIT $5`,
+		args...)
 	return urls, err
 }
 
-func StoreURLView(url URL) error {
-	db, err := db()
-	if err != nil {
-		return err
+func shuffleCursor(urls []URL, seed float64, page Page) *Cursor {
+	cursor := nextCursor(OrderShuffle, urls, page)
+	if cursor != nil {
+		cursor.Seed = seed
 	}
-	_, err = db.Exec(`INSERT INTO url_views (url_id) VALUES ($1)`, url.ID)
+	return cursor
+}
+
+type SubRedditCount struct {
+	Name  string `db:"name" json:"name"`
+	Count int    `db:"count" json:"count"`
+}
+
+// GetSubRedditCounts returns every subreddit gifs have been ingested from,
+// along with how many gifs each has, biggest first.
+func (p *Postgres) GetSubRedditCounts(ctx context.Context, nsfw bool) ([]SubRedditCount, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var counts []SubRedditCount
+	err := p.db.SelectContext(ctx, &counts, `
+		SELECT subreddit AS name, COUNT(*) AS count
+			FROM urls
+			WHERE nsfw = $1 AND subreddit <> '' AND `+working+`
+			GROUP BY subreddit
+			ORDER BY count DESC, name`,
+		nsfw)
+	return counts, err
+}
+
+// GetURLsToCheck returns urls whose links have never been checked or were
+// last checked before checkedBefore, least recently checked first. urls
+// that have started failing but aren't hidden yet are rechecked sooner,
+// once they were last checked before failingCheckedBefore, and come
+// before everything else so that dead links are hidden quickly.
+func (p *Postgres) GetURLsToCheck(ctx context.Context, checkedBefore time.Time, failingCheckedBefore time.Time, limit int) ([]URL, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var urls []URL
+	err := p.db.SelectContext(ctx, &urls, `
+		SELECT * FROM urls
+			WHERE link_checked_at IS NULL OR link_checked_at < $1
+			OR (link_failures > 0 AND link_failures < $2 AND link_checked_at < $3)
+			ORDER BY link_checked_at IS NOT NULL, link_failures > 0 AND link_failures < $2 DESC, link_checked_at ASC
+			LIMIT $4`,
+		checkedBefore, MaximumLinkFailures, failingCheckedBefore, limit)
+	return urls, err
+}
+
+// StoreLinkCheck records the outcome of checking a url's links, which is
+// one of LinkWorking, LinkBroken or LinkInconclusive, counting how many
+// checks in a row have found them broken.
+func (p *Postgres) StoreLinkCheck(ctx context.Context, id int, status int, outcome string) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE urls SET
+			link_status = $1,
+			link_checked_at = now(),
+			link_failures = CASE $2
+				WHEN 'working' THEN 0
+				WHEN 'broken' THEN link_failures + 1
+				ELSE link_failures
+			END
+		WHERE id = $3`,
+		status, outcome, id)
 	return err
 }
+
+// GetBrokenURLs returns urls that are hidden because of broken links.
+func (p *Postgres) GetBrokenURLs(ctx context.Context, page int, pageSize int) ([]URL, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var urls []URL
+	err := p.db.SelectContext(ctx, &urls, `
+		SELECT * FROM urls
+			WHERE link_failures >= $1
+			ORDER BY link_checked_at DESC
+			LIMIT $2 OFFSET $3`,
+		MaximumLinkFailures, pageSize, (page-1)*pageSize)
+	return urls, err
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShareMarkdown(t *testing.T) {
+	examples := []struct {
+		URL      URL
+		Expected string
+	}{
+		{URL{URL: "https://i.imgur.com/a.gif"}, "![https://i.imgur.com/a.gif](https://i.imgur.com/a.gif)"},
+		{URL{URL: "https://i.imgur.com/a.mp4"}, "[https://i.imgur.com/a.mp4](https://i.imgur.com/a.mp4)"},
+		{
+			URL{URL: "https://i.imgur.com/a.mp4?1", ThumbnailURL: "https://media/a.jpg"},
+			"[![https://i.imgur.com/a.mp4?1](https://media/a.jpg)](https://i.imgur.com/a.mp4?1)",
+		},
+	}
+	for _, example := range examples {
+		if actual := example.URL.ShareMarkdown(); actual != example.Expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.Expected, actual)
+		}
+	}
+}
+
+func TestMemoryGetURLsToCheck(t *testing.T) {
+	now := time.Now()
+	checked := func(ago time.Duration) *time.Time {
+		at := now.Add(-ago)
+		return &at
+	}
+	store := NewMemory()
+	for _, url := range []URL{
+		{Title: "recent", LinkCheckedAt: checked(time.Hour)},
+		{Title: "old", LinkCheckedAt: checked(8 * 24 * time.Hour)},
+		{Title: "failing", LinkFailures: 1, LinkCheckedAt: checked(2 * time.Hour)},
+		{Title: "failing recently", LinkFailures: 1, LinkCheckedAt: checked(time.Minute)},
+		{Title: "hidden", LinkFailures: MaximumLinkFailures, LinkCheckedAt: checked(2 * time.Hour)},
+		{Title: "never"},
+	} {
+		store.SaveURL(context.Background(), &url)
+	}
+
+	urls, err := store.GetURLsToCheck(context.Background(), now.Add(-7*24*time.Hour), now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, url := range urls {
+		actual = append(actual, url.Title)
+	}
+	expected := []string{"never", "failing", "old"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestMemoryUpdateURLOnlyUpdatesTheSamePost(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	original := URL{
+		URL:       "https://i.imgur.com/a.gif",
+		SourceURL: "https://reddit.com/r/gifs/comments/a/",
+		SubReddit: "gifs",
+		Author:    "someone",
+		Score:     10,
+	}
+	store.SaveURL(ctx, &original)
+
+	examples := []struct {
+		update   URL
+		expected int
+	}{
+		{URL{URL: original.URL, SourceURL: "https://reddit.com/r/funny/comments/b/", SubReddit: "funny", Author: "reposter", Score: 1}, 10},
+		{URL{URL: original.URL, SourceURL: original.SourceURL, SubReddit: "gifs", Author: "someone", Score: 20}, 20},
+	}
+
+	for _, example := range examples {
+		id, err := store.ExistsInDB(ctx, example.update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateURL(ctx, id, example.update); err != nil {
+			t.Fatal(err)
+		}
+		url, err := store.GetURL(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url.Score != example.expected || url.SubReddit != "gifs" || url.Author != "someone" {
+			t.Errorf("Expected a score of %v from r/gifs by someone\nGot:\n%+v\n", example.expected, url)
+		}
+	}
+}
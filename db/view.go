@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Windows the top listing can be limited to.
+const (
+	TopDay   = "day"
+	TopWeek  = "week"
+	TopMonth = "month"
+	TopAll   = "all"
+)
+
+// Views are added up into hourly and daily buckets as they're stored, so
+// that the top listings don't have to count every view on every request.
+const (
+	hourly = "hour"
+	daily  = "day"
+)
+
+var bucketSizes = map[string]time.Duration{
+	hourly: time.Hour,
+	daily:  24 * time.Hour,
+}
+
+// bucketRetention is how long buckets are kept for, which is a bucket
+// longer than the longest window that adds them up, since windows end at
+// the last full hour rather than now and start at a whole day.
+var bucketRetention = map[string]time.Duration{
+	hourly: 49 * time.Hour,
+	daily:  32 * 24 * time.Hour,
+}
+
+// topWindows are which buckets each window adds up, and how many of them.
+var topWindows = map[string]struct {
+	period  string
+	buckets int
+}{
+	TopDay:   {hourly, 24},
+	TopWeek:  {daily, 7},
+	TopMonth: {daily, 30},
+}
+
+// TrendingWindow is how far back views count towards the trending listing,
+// and TrendingHalfLife is how quickly they stop counting: a view that's
+// TrendingHalfLife old is worth half as much as one from just now.
+const (
+	TrendingWindow   = 48 * time.Hour
+	TrendingHalfLife = 6 * time.Hour
+)
+
+func ValidTopWindow(window string) bool {
+	_, ok := topWindows[window]
+	return ok || window == TopAll
+}
+
+// windowStart returns the start of the oldest bucket in window at now.
+// Windows made of days start at the beginning of the day their buckets
+// reach back to, so that they always cover the whole of them.
+func windowStart(window string, now time.Time) time.Time {
+	w := topWindows[window]
+	size := bucketSizes[w.period]
+	return windowEnd(now).Add(-time.Duration(w.buckets) * size).Truncate(size)
+}
+
+func trendingStart(now time.Time) time.Time {
+	return windowEnd(now).Add(-TrendingWindow)
+}
+
+// windowEnd returns the end of the last full hour at now. Views after it
+// are still being added up, so they're left out of the listings until the
+// hour is over, otherwise later pages would count more views than earlier
+// ones and gifs would move between them.
+func windowEnd(now time.Time) time.Time {
+	return now.UTC().Truncate(time.Hour)
+}
+
+// windowBounds returns when the window that page is in starts and ends.
+// Cursors made before there was an end carry on with the current one.
+func windowBounds(page Page, start time.Time, now time.Time) (time.Time, time.Time) {
+	since, until := start, windowEnd(now)
+	if page.After != nil {
+		since = page.After.Since
+		if !page.After.Until.IsZero() {
+			until = page.After.Until
+		}
+	}
+	return since, until
+}
+
+// topOrder is the cursor order for the top listing in window. All time
+// keeps the order it had before there were windows so that old cursors
+// still work.
+func topOrder(window string) string {
+	if window == TopAll {
+		return OrderTop
+	}
+	return OrderTop + "/" + window
+}
+
+// windowCursor returns the cursor for the page after urls, remembering
+// when the window started and ended so that later pages add up the same
+// buckets.
+func windowCursor(order string, urls []URL, since time.Time, until time.Time, page Page) *Cursor {
+	cursor := nextCursor(order, urls, page)
+	if cursor != nil {
+		cursor.Since = since
+		cursor.Until = until
+	}
+	return cursor
+}
+
+// GetTopURLs returns the most viewed urls in window, which is one of
+// TopDay, TopWeek, TopMonth or TopAll. Only urls viewed in the window are
+// listed, apart from all time which lists everything. All time leaves out
+// the views since the window ended too, so that its pages don't shift.
+func (p *Postgres) GetTopURLs(ctx context.Context, filter Filter, nsfw bool, window string, page Page) ([]URL, *Cursor, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	if !ValidTopWindow(window) {
+		return nil, nil, fmt.Errorf("invalid top window %q", window)
+	}
+	order := topOrder(window)
+	after, err := page.after(order)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var urls []URL
+	now := time.Now()
+	if window == TopAll {
+		since, until := windowBounds(page, time.Time{}, now)
+		conditions, args := filter.conditions([]interface{}{nsfw, until})
+		if after != nil {
+			args = append(args, after.Views, after.ID)
+			conditions += fmt.Sprintf(" AND (urls.views - COALESCE(recent.views, 0), urls.id) < ($%d, $%d)", len(args)-1, len(args))
+		}
+		limit, args := page.limit(args)
+		err = p.db.SelectContext(ctx, &urls, `
+		SELECT urls.*, urls.views - COALESCE(recent.views, 0) AS views FROM urls
+			LEFT JOIN (
+				SELECT url_id, SUM(views)::int AS views FROM url_view_counts
+					WHERE period = 'hour' AND bucket >= $2
+					GROUP BY url_id
+			) AS recent ON recent.url_id = urls.id
+			WHERE urls.nsfw = $1`+conditions+`
+			ORDER BY urls.views - COALESCE(recent.views, 0) DESC, urls.id DESC
+			`+limit,
+			args...)
+		return urls, windowCursor(order, urls, since, until, page), err
+	}
+
+	since, until := windowBounds(page, windowStart(window, now), now)
+	// Windows made of days add up today's hours, since today's bucket
+	// isn't finished.
+	split := since
+	if topWindows[window].period == daily {
+		split = until.Truncate(bucketSizes[daily])
+	}
+	conditions, args := filter.conditions([]interface{}{nsfw, since, split, until})
+	if after != nil {
+		args = append(args, after.Views, after.ID)
+		conditions += fmt.Sprintf(" AND (counts.views, urls.id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	limit, args := page.limit(args)
+	// counts.views comes after urls.views, so it's the one that's kept.
+	err = p.db.SelectContext(ctx, &urls, `
+		SELECT urls.*, counts.views FROM urls
+			INNER JOIN (
+				SELECT url_id, SUM(views)::int AS views FROM url_view_counts
+					WHERE (period = 'day' AND bucket >= $2 AND bucket < $3)
+					OR (period = 'hour' AND bucket >= $3 AND bucket < $4)
+					GROUP BY url_id
+			) AS counts ON counts.url_id = urls.id
+			WHERE urls.nsfw = $1`+conditions+`
+			ORDER BY counts.views DESC, urls.id DESC
+			`+limit,
+		args...)
+	return urls, windowCursor(order, urls, since, until, page), err
+}
+
+// GetTrendingURLs returns the urls with the most views recently, where
+// every view counts for less the older it is.
+func (p *Postgres) GetTrendingURLs(ctx context.Context, filter Filter, nsfw bool, page Page) ([]URL, *Cursor, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	after, err := page.after(OrderTrending)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	since, until := windowBounds(page, trendingStart(now), now)
+	// Views are weighted relative to the start of the window rather than
+	// to now, so that scores don't change between one page and the next.
+	conditions, args := filter.conditions([]interface{}{nsfw, since, TrendingHalfLife.Seconds(), until})
+	if after != nil {
+		args = append(args, after.Rank, after.ID)
+		conditions += fmt.Sprintf(" AND (trending.rank, urls.id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	limit, args := page.limit(args)
+
+	var urls []URL
+	err = p.db.SelectContext(ctx, &urls, `
+		SELECT urls.*, trending.rank FROM urls
+			INNER JOIN (
+				SELECT url_id, SUM(views * power(2, extract(epoch FROM bucket - $2) / $3))::float8 AS rank
+					FROM url_view_counts
+					WHERE period = 'hour' AND bucket >= $2 AND bucket < $4
+					GROUP BY url_id
+			) AS trending ON trending.url_id = urls.id
+			WHERE urls.nsfw = $1`+conditions+`
+			ORDER BY trending.rank DESC, urls.id DESC
+			`+limit,
+		args...)
+	return urls, windowCursor(OrderTrending, urls, since, until, page), err
+}
+
+// View is somebody looking at a gif.
+type View struct {
+	URLID     int
+	CreatedAt time.Time
+}
+
+// StoreURLViews records views, adding them to the buckets that the top
+// listings count. It's meant to be given views in batches, since every
+// call is a handful of queries however many views there are.
+func (p *Postgres) StoreURLViews(ctx context.Context, views []View) error {
+	if len(views) == 0 {
+		return nil
+	}
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	ids := make([]int64, len(views))
+	times := make([]string, len(views))
+	for i, view := range views {
+		ids[i] = int64(view.URLID)
+		times[i] = view.CreatedAt.UTC().Format("2006-01-02 15:04:05.999999")
+	}
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	CREATE TEMPORARY TABLE new_views ON COMMIT DROP AS
+		SELECT * FROM unnest($1::int[], $2::timestamp[]) AS views(url_id, created_at)`,
+		pq.Array(ids), pq.Array(times))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO url_views (url_id, created_at) SELECT url_id, created_at FROM new_views`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO url_view_counts (url_id, period, bucket, views)
+		SELECT url_id, 'hour', date_trunc('hour', created_at), COUNT(*) FROM new_views GROUP BY 1, 3
+		UNION ALL
+		SELECT url_id, 'day', date_trunc('day', created_at), COUNT(*) FROM new_views GROUP BY 1, 3
+		ON CONFLICT (period, bucket, url_id) DO UPDATE SET
+			views = url_view_counts.views + EXCLUDED.views`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+	UPDATE urls SET views = urls.views + counts.views
+		FROM (SELECT url_id, COUNT(*) AS views FROM new_views GROUP BY url_id) AS counts
+		WHERE urls.id = counts.url_id`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReapViewCounts deletes buckets that are too old to be in any window.
+func (p *Postgres) ReapViewCounts(ctx context.Context) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	_, err := p.db.ExecContext(ctx, `
+	DELETE FROM url_view_counts
+		WHERE (period = 'hour' AND bucket < $1)
+		OR (period = 'day' AND bucket < $2)`,
+		now.Add(-bucketRetention[hourly]), now.Add(-bucketRetention[daily]))
+	return err
+}
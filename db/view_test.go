@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindowStart(t *testing.T) {
+	now := time.Date(2015, 7, 11, 18, 30, 0, 0, time.UTC)
+	examples := []struct {
+		window   string
+		expected time.Time
+	}{
+		{TopDay, time.Date(2015, 7, 10, 18, 0, 0, 0, time.UTC)},
+		{TopWeek, time.Date(2015, 7, 4, 0, 0, 0, 0, time.UTC)},
+		{TopMonth, time.Date(2015, 6, 11, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, example := range examples {
+		actual := windowStart(example.window, now)
+		if !actual.Equal(example.expected) {
+			t.Errorf("Expected %v to start at:\n%v\nGot:\n%v\n", example.window, example.expected, actual)
+		}
+	}
+	if actual, expected := trendingStart(now), time.Date(2015, 7, 9, 18, 0, 0, 0, time.UTC); !actual.Equal(expected) {
+		t.Errorf("Expected trending to start at:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestMemoryTopWindows(t *testing.T) {
+	now := time.Date(2015, 7, 11, 18, 30, 0, 0, time.UTC)
+	store := NewMemory()
+	for _, title := range []string{"a", "b", "c", "d"} {
+		store.SaveURL(context.Background(), &URL{Title: title})
+	}
+	views := []struct {
+		id  int
+		ago time.Duration
+	}{
+		{1, time.Hour}, {1, 3 * 24 * time.Hour}, {1, 3 * 24 * time.Hour},
+		{2, 90 * time.Minute}, {2, 2 * time.Hour},
+		{4, time.Minute},
+		{3, 20 * 24 * time.Hour}, {3, 20 * 24 * time.Hour}, {3, 20 * 24 * time.Hour},
+	}
+	for _, view := range views {
+		store.StoreURLViews(context.Background(), []View{{URLID: view.id, CreatedAt: now.Add(-view.ago)}})
+	}
+	store.now = func() time.Time { return now }
+
+	examples := []struct {
+		window   string
+		expected []string
+	}{
+		{TopDay, []string{"b", "a"}},
+		{TopWeek, []string{"a", "b"}},
+		{TopMonth, []string{"c", "a", "b"}},
+		{TopAll, []string{"c", "a", "b", "d"}},
+	}
+
+	for _, example := range examples {
+		urls, _, err := store.GetTopURLs(context.Background(), Filter{}, false, example.window, Page{Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := []string{}
+		for _, url := range urls {
+			actual = append(actual, url.Title)
+		}
+		if !reflect.DeepEqual(actual, example.expected) {
+			t.Errorf("Expected %v to list:\n%v\nGot:\n%v\n", example.window, example.expected, actual)
+		}
+	}
+
+	urls, _, err := store.GetTrendingURLs(context.Background(), Filter{}, false, Page{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 2 || urls[0].Title != "b" || urls[1].Title != "a" {
+		t.Errorf("Expected b to be trending above a\nGot:\n%v\n", urls)
+	}
+}
+
+func TestMemoryTopPagesKeepTheirWindow(t *testing.T) {
+	now := time.Date(2015, 7, 11, 18, 30, 0, 0, time.UTC)
+	store := NewMemory()
+	for _, title := range []string{"a", "b", "c"} {
+		store.SaveURL(context.Background(), &URL{Title: title})
+	}
+	for id, count := range map[int]int{1: 3, 2: 2, 3: 1} {
+		for i := 0; i < count; i++ {
+			store.StoreURLViews(context.Background(), []View{{URLID: id, CreatedAt: now.Add(-time.Hour)}})
+		}
+	}
+	store.now = func() time.Time { return now }
+
+	urls, next, err := store.GetTopURLs(context.Background(), Filter{}, false, TopDay, Page{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Title != "a" {
+		t.Fatalf("Expected:\n%v\nGot:\n%v\n", "a", urls)
+	}
+
+	// c gets more views than anything else while the first page is being
+	// looked at, but the next page still adds up the same views.
+	for i := 0; i < 5; i++ {
+		store.StoreURLViews(context.Background(), []View{{URLID: 3, CreatedAt: now}})
+	}
+	store.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	urls, _, err = store.GetTopURLs(context.Background(), Filter{}, false, TopDay, Page{Size: 1, After: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Title != "b" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "b", urls)
+	}
+}
+
+func TestMemoryTopAllPagesKeepTheirWindow(t *testing.T) {
+	now := time.Date(2015, 7, 11, 18, 30, 0, 0, time.UTC)
+	store := NewMemory()
+	for _, title := range []string{"a", "b", "c"} {
+		store.SaveURL(context.Background(), &URL{Title: title})
+	}
+	for id, count := range map[int]int{1: 3, 2: 2, 3: 1} {
+		for i := 0; i < count; i++ {
+			store.StoreURLViews(context.Background(), []View{{URLID: id, CreatedAt: now.Add(-time.Hour)}})
+		}
+	}
+	store.now = func() time.Time { return now }
+
+	urls, next, err := store.GetTopURLs(context.Background(), Filter{}, false, TopAll, Page{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Title != "a" {
+		t.Fatalf("Expected:\n%v\nGot:\n%v\n", "a", urls)
+	}
+
+	// c overtakes b while the first page is being looked at, but the next
+	// page still counts the views from before it.
+	for i := 0; i < 5; i++ {
+		store.StoreURLViews(context.Background(), []View{{URLID: 3, CreatedAt: now}})
+	}
+	store.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	urls, _, err = store.GetTopURLs(context.Background(), Filter{}, false, TopAll, Page{Size: 2, After: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 2 || urls[0].Title != "b" || urls[1].Title != "c" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "b, c", urls)
+	}
+}
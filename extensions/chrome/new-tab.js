@@ -29,10 +29,19 @@
       var video = document.createElement("video"); 
       video.setAttribute("loop", true);
       video.setAttribute("autoplay", true);
-      var webmSource = document.createElement("source");
-      webmSource.setAttribute("src", randomGif.WEBMURL);
-      webmSource.setAttribute("type", "video/webm");
-      video.appendChild(webmSource);
+      // Gifs that came as video only have an mp4.
+      if (randomGif.MP4URL) {
+        var mp4Source = document.createElement("source");
+        mp4Source.setAttribute("src", randomGif.MP4URL);
+        mp4Source.setAttribute("type", "video/mp4");
+        video.appendChild(mp4Source);
+      }
+      if (randomGif.WEBMURL && randomGif.WEBMURL != randomGif.MP4URL) {
+        var webmSource = document.createElement("source");
+        webmSource.setAttribute("src", randomGif.WEBMURL);
+        webmSource.setAttribute("type", "video/webm");
+        video.appendChild(webmSource);
+      }
       gifElement.appendChild(video);
     }
   }
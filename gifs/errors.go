@@ -0,0 +1,24 @@
+package gifs
+
+import "fmt"
+
+// StatusError is returned when a gif, or the server transcoding it,
+// responds with an unexpected http status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http status was %d for %v", e.StatusCode, e.URL)
+}
+
+// TranscodeError is returned when a gif can't be transcoded no matter how
+// many times it's tried, because it's too big or isn't a gif at all.
+type TranscodeError struct {
+	Reason string
+}
+
+func (e *TranscodeError) Error() string {
+	return e.Reason
+}
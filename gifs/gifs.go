@@ -1,9 +1,8 @@
 package gifs
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -18,7 +17,7 @@ type GifInformation struct {
 	JPGURL  string `json:"jpgurl"`
 }
 
-func Gif(host string, gifURL string) (GifInformation, error) {
+func Gif(ctx context.Context, host string, gifURL string) (GifInformation, error) {
 	uploadURL, err := url.Parse(host + "/upload")
 	if err != nil {
 		return GifInformation{}, err
@@ -27,7 +26,11 @@ func Gif(host string, gifURL string) (GifInformation, error) {
 	q.Set("u", gifURL)
 	uploadURL.RawQuery = q.Encode()
 
-	response, err := http.Get(uploadURL.String())
+	request, err := http.NewRequestWithContext(ctx, "GET", uploadURL.String(), nil)
+	if err != nil {
+		return GifInformation{}, err
+	}
+	response, err := gifsClient.Do(request)
 	if err != nil {
 		return GifInformation{}, err
 	}
@@ -39,7 +42,7 @@ func Gif(host string, gifURL string) (GifInformation, error) {
 	}
 
 	if response.StatusCode != 200 {
-		return GifInformation{}, errors.New(fmt.Sprintf("http status was %d", response.StatusCode))
+		return GifInformation{}, &StatusError{URL: uploadURL.String(), StatusCode: response.StatusCode}
 	}
 
 	var information GifInformation
@@ -48,7 +51,7 @@ func Gif(host string, gifURL string) (GifInformation, error) {
 		return GifInformation{}, err
 	}
 	if information.Error != "" {
-		return GifInformation{}, errors.New(information.Error)
+		return GifInformation{}, &TranscodeError{Reason: information.Error}
 	}
 
 	return information, nil
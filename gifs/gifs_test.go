@@ -0,0 +1,50 @@
+package gifs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPTranscoder(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		w.Write([]byte(`{"width": 320, "height": 240, "webmurl": "http://gifs/a.webm", "mp4url": "http://gifs/a.mp4", "jpgurl": "http://gifs/a.jpg"}`))
+	}))
+	defer server.Close()
+
+	transcoder := HTTPTranscoder{Host: server.URL}
+	actual, err := transcoder.Transcode(context.Background(), "http://i.imgur.com/Zx9sVdz.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := GifInformation{
+		Width:   320,
+		Height:  240,
+		WEBMURL: "http://gifs/a.webm",
+		MP4URL:  "http://gifs/a.mp4",
+		JPGURL:  "http://gifs/a.jpg",
+	}
+	if actual != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+	if expectedRequest := "/upload?u=http%3A%2F%2Fi.imgur.com%2FZx9sVdz.gif"; requested != expectedRequest {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedRequest, requested)
+	}
+}
+
+func TestHTTPTranscoderErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": "not a gif"}`))
+	}))
+	defer server.Close()
+
+	transcoder := HTTPTranscoder{Host: server.URL}
+	_, err := transcoder.Transcode(context.Background(), "http://example.com/a.jpg")
+	if err == nil || err.Error() != "not a gif" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "not a gif", err)
+	}
+}
@@ -0,0 +1,74 @@
+package gifs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"path/filepath"
+)
+
+// remoteInput are the options for reading media from somebody else's
+// server with ffmpeg or ffprobe. Reads that stall for 30 seconds fail, and
+// only plain http can be used, so that a playlist can't point them at
+// local files or other protocols.
+var remoteInput = []string{
+	"-rw_timeout", "30000000",
+	"-protocol_whitelist", "http,https,tcp,tls",
+}
+
+// FFprobe returns the ffprobe that's alongside ffmpeg, or the one on $PATH
+// when ffmpeg is empty.
+func FFprobe(ffmpeg string) string {
+	if ffmpeg != "" {
+		return filepath.Join(filepath.Dir(ffmpeg), "ffprobe")
+	}
+	return "ffprobe"
+}
+
+// FFmpeg returns ffmpeg, or the one on $PATH when it's empty.
+func FFmpeg(ffmpeg string) string {
+	if ffmpeg != "" {
+		return ffmpeg
+	}
+	return "ffmpeg"
+}
+
+type probeResult struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+}
+
+// Probe uses ffprobe to find the dimensions of the first video stream at
+// the remote videoURL. ffprobe is killed if ctx is cancelled or it takes
+// longer than DefaultTranscodeTimeout.
+func Probe(ctx context.Context, ffprobe string, videoURL string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTranscodeTimeout)
+	defer cancel()
+	return probe(ctx, ffprobe, videoURL, remoteInput...)
+}
+
+func probe(ctx context.Context, ffprobe string, videoURL string, inputArgs ...string) (int, int, error) {
+	args := append([]string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height",
+		"-of", "json",
+	}, inputArgs...)
+	out, err := exec.CommandContext(ctx, ffprobe, append(args, videoURL)...).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var result probeResult
+	err = json.Unmarshal(out, &result)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(result.Streams) == 0 || result.Streams[0].Width == 0 {
+		return 0, 0, errors.New("no video stream found in " + videoURL)
+	}
+	return result.Streams[0].Width, result.Streams[0].Height, nil
+}
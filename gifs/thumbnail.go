@@ -0,0 +1,48 @@
+package gifs
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/AndrewVos/ancientcitadel/storage"
+)
+
+// Thumbnail uses ffmpeg to save the first frame of the remote video at
+// videoURL to store as a jpg, for gifs that come as video and so never go
+// through a Transcoder. It returns the url of the jpg. ffmpeg is killed if
+// ctx is cancelled or it takes longer than DefaultTranscodeTimeout.
+func Thumbnail(ctx context.Context, ffmpeg string, store storage.Store, videoURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTranscodeTimeout)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "ancientcitadel")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	thumbnailPath := filepath.Join(dir, "thumbnail.jpg")
+	args := append([]string{"-v", "error", "-y"}, remoteInput...)
+	args = append(args, "-i", videoURL, "-vframes", "1", thumbnailPath)
+	out, err := exec.CommandContext(ctx, ffmpeg, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg couldn't make a thumbnail: %v: %s", err, out)
+	}
+
+	file, err := os.Open(thumbnailPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	name := fmt.Sprintf("%x.jpg", sha1.Sum([]byte(videoURL)))
+	if err := store.Put(name, file, "image/jpeg"); err != nil {
+		return "", err
+	}
+	return store.URL(name), nil
+}
@@ -0,0 +1,185 @@
+package gifs
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/storage"
+)
+
+// Transcoder turns a gif into mp4 and webm videos with a jpg thumbnail,
+// giving up when ctx is cancelled.
+type Transcoder interface {
+	Transcode(ctx context.Context, gifURL string) (GifInformation, error)
+}
+
+// HTTPTranscoder sends gifs to a remote gifs server to be transcoded.
+type HTTPTranscoder struct {
+	Host string
+}
+
+func (t HTTPTranscoder) Transcode(ctx context.Context, gifURL string) (GifInformation, error) {
+	return Gif(ctx, t.Host, gifURL)
+}
+
+func (t HTTPTranscoder) String() string {
+	return t.Host
+}
+
+// MaximumGifSize is the largest gif LocalTranscoder will download.
+const MaximumGifSize = 100 * 1024 * 1024
+
+// DefaultTranscodeTimeout is how long LocalTranscoder spends on a gif,
+// downloading and running ffmpeg, before giving up on it.
+const DefaultTranscodeTimeout = 10 * time.Minute
+
+// gifsClient is used for requests to the gifs servers, and for downloads
+// when there isn't a Client, so that a host that stops responding can't
+// hold on to a worker forever.
+var gifsClient = &http.Client{Timeout: 5 * time.Minute}
+
+// LocalTranscoder downloads gifs and transcodes them with ffmpeg,
+// putting the results in Store.
+type LocalTranscoder struct {
+	Store storage.Store
+	// FFmpeg is the ffmpeg binary to run, found on $PATH by default.
+	// ffprobe is expected to be alongside it.
+	FFmpeg string
+	Client *http.Client
+	// Timeout is how long a transcode can take, DefaultTranscodeTimeout
+	// when it's zero.
+	Timeout time.Duration
+}
+
+func (t LocalTranscoder) String() string {
+	return "local"
+}
+
+type output struct {
+	extension   string
+	contentType string
+	args        []string
+}
+
+var outputs = []output{
+	{"mp4", "video/mp4", []string{
+		"-movflags", "faststart",
+		"-pix_fmt", "yuv420p",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+	}},
+	{"webm", "video/webm", []string{
+		"-c:v", "libvpx",
+		"-crf", "12",
+		"-b:v", "1M",
+		"-auto-alt-ref", "0",
+	}},
+	{"jpg", "image/jpeg", []string{
+		"-vframes", "1",
+	}},
+}
+
+func (t LocalTranscoder) Transcode(ctx context.Context, gifURL string) (GifInformation, error) {
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = DefaultTranscodeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "ancientcitadel")
+	if err != nil {
+		return GifInformation{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	gifPath := filepath.Join(dir, "original.gif")
+	err = t.download(ctx, gifURL, gifPath)
+	if err != nil {
+		return GifInformation{}, err
+	}
+
+	information := GifInformation{}
+	information.Width, information.Height, err = probe(ctx, t.ffprobe(), gifPath)
+	if err != nil {
+		return GifInformation{}, err
+	}
+
+	name := fmt.Sprintf("%x", sha1.Sum([]byte(gifURL)))
+	urls := map[string]string{}
+	for _, output := range outputs {
+		outputPath := filepath.Join(dir, "transcoded."+output.extension)
+		args := append([]string{"-v", "error", "-y", "-i", gifPath}, output.args...)
+		args = append(args, outputPath)
+		out, err := exec.CommandContext(ctx, t.ffmpeg(), args...).CombinedOutput()
+		if err != nil {
+			return GifInformation{}, fmt.Errorf("ffmpeg couldn't make %v: %v: %s", output.extension, err, out)
+		}
+
+		file, err := os.Open(outputPath)
+		if err != nil {
+			return GifInformation{}, err
+		}
+		fileName := name + "." + output.extension
+		err = t.Store.Put(fileName, file, output.contentType)
+		file.Close()
+		if err != nil {
+			return GifInformation{}, err
+		}
+		urls[output.extension] = t.Store.URL(fileName)
+	}
+
+	information.MP4URL = urls["mp4"]
+	information.WEBMURL = urls["webm"]
+	information.JPGURL = urls["jpg"]
+	return information, nil
+}
+
+func (t LocalTranscoder) download(ctx context.Context, gifURL string, path string) error {
+	client := t.Client
+	if client == nil {
+		client = gifsClient
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", gifURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return &StatusError{URL: gifURL, StatusCode: response.StatusCode}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(response.Body, MaximumGifSize+1))
+	if err != nil {
+		return err
+	}
+	if n > MaximumGifSize {
+		return &TranscodeError{Reason: "gif is too big to transcode"}
+	}
+	return nil
+}
+
+func (t LocalTranscoder) ffmpeg() string {
+	return FFmpeg(t.FFmpeg)
+}
+
+func (t LocalTranscoder) ffprobe() string {
+	return FFprobe(t.FFmpeg)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"compress/gzip"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
@@ -10,8 +11,10 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/AndrewVos/ancientcitadel/config"
 	"github.com/AndrewVos/ancientcitadel/db"
 	"github.com/ChimeraCoder/anaconda"
 	"github.com/gorilla/handlers"
@@ -19,12 +22,18 @@ import (
 	"github.com/mrjones/oauth"
 )
 
-func twitterCallbackHandler(w http.ResponseWriter, r *http.Request) {
+// siteHandlers are the handlers that live outside of the controllers.
+type siteHandlers struct {
+	config *config.Config
+	store  db.Store
+}
+
+func (h *siteHandlers) twitterCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
 	c := oauth.NewConsumer(
-		os.Getenv("TWITTER_CONSUMER_KEY"),
-		os.Getenv("TWITTER_CONSUMER_SECRET"),
+		h.config.TwitterConsumerKey,
+		h.config.TwitterConsumerSecret,
 		oauth.ServiceProvider{
 			RequestTokenUrl:   "https://api.twitter.com/oauth/request_token",
 			AuthorizeTokenUrl: "https://api.twitter.com/oauth/authorize",
@@ -61,7 +70,7 @@ func twitterCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 var tokens = map[string]*oauth.RequestToken{}
 
-func tweetHandler(w http.ResponseWriter, r *http.Request) {
+func (h *siteHandlers) tweetHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
 	var twitterToken string
@@ -75,8 +84,8 @@ func tweetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	c := oauth.NewConsumer(
-		os.Getenv("TWITTER_CONSUMER_KEY"),
-		os.Getenv("TWITTER_CONSUMER_SECRET"),
+		h.config.TwitterConsumerKey,
+		h.config.TwitterConsumerSecret,
 		oauth.ServiceProvider{
 			RequestTokenUrl:   "https://api.twitter.com/oauth/request_token",
 			AuthorizeTokenUrl: "https://api.twitter.com/oauth/authorize",
@@ -84,7 +93,7 @@ func tweetHandler(w http.ResponseWriter, r *http.Request) {
 		})
 
 	if twitterToken == "" || twitterSecret == "" {
-		token, requestURL, err := c.GetRequestTokenAndUrl("http://ancientcitadel.com/twitter/callback")
+		token, requestURL, err := c.GetRequestTokenAndUrl(h.config.URL("/twitter/callback"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			log.Print(err)
@@ -102,42 +111,50 @@ func tweetHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		gif, err := db.GetURL(id)
+		gif, err := h.store.GetURL(r.Context(), id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			log.Print(err)
 			return
 		}
 
-		anaconda.SetConsumerKey(os.Getenv("TWITTER_CONSUMER_KEY"))
-		anaconda.SetConsumerSecret(os.Getenv("TWITTER_CONSUMER_SECRET"))
+		anaconda.SetConsumerKey(h.config.TwitterConsumerKey)
+		anaconda.SetConsumerSecret(h.config.TwitterConsumerSecret)
 		api := anaconda.NewTwitterApi(twitterToken, twitterSecret)
 
-		gifResponse, err := http.Get(gif.URL)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Print(err)
-			return
-		}
-		defer gifResponse.Body.Close()
+		// UploadMedia only takes images, so gifs that came as video are
+		// tweeted with their thumbnail, or with just the link if there
+		// isn't one.
+		v := url.Values{}
+		if imageURL := gif.ImageURL(); imageURL != "" {
+			if strings.HasPrefix(imageURL, "/") {
+				imageURL = h.config.URL(imageURL)
+			}
+			imageResponse, err := http.Get(imageURL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				log.Print(err)
+				return
+			}
+			defer imageResponse.Body.Close()
 
-		b, err := ioutil.ReadAll(gifResponse.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Print(err)
-			return
-		}
-		base64Encoded := base64.StdEncoding.EncodeToString(b)
-		media, err := api.UploadMedia(base64Encoded)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Print(err)
-			return
+			b, err := ioutil.ReadAll(imageResponse.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				log.Print(err)
+				return
+			}
+			base64Encoded := base64.StdEncoding.EncodeToString(b)
+			media, err := api.UploadMedia(base64Encoded)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				log.Print(err)
+				return
+			}
+			v.Set("media_ids", media.MediaIDString)
 		}
 
-		v := url.Values{}
-		v.Set("media_ids", media.MediaIDString)
-		_, err = api.PostTweet("http://ancientcitadel.com/"+gif.Permalink(), v)
+		_, err = api.PostTweet(h.config.URL(gif.Permalink()), v)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			log.Print(err)
@@ -146,7 +163,7 @@ func tweetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+func (h *siteHandlers) sitemapHandler(w http.ResponseWriter, r *http.Request) {
 	gzip := gzip.NewWriter(w)
 	defer gzip.Close()
 
@@ -158,30 +175,28 @@ func sitemapHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := 1
+	page := db.Page{Size: 1000}
 	for {
-		var urls []db.URL
-
-		urls, err := db.GetURLs("", false, page, 1000)
+		urls, next, err := h.store.GetURLs(r.Context(), "", db.Filter{}, false, page)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			log.Print(err)
 			return
 		}
 
-		if len(urls) == 0 {
-			break
-		}
-
 		for _, url := range urls {
-			_, err := gzip.Write([]byte(fmt.Sprintf("  <url><loc>http://ancientcitadel.com%v</loc></url>\n", url.Permalink())))
+			_, err := gzip.Write([]byte(fmt.Sprintf("  <url><loc>%v</loc></url>\n", h.config.URL(url.Permalink()))))
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				log.Print(err)
 				return
 			}
 		}
-		page += 1
+
+		if next == nil {
+			break
+		}
+		page.After = next
 	}
 	gzip.Write([]byte("</urlset>\n"))
 }
@@ -190,6 +205,22 @@ func loggingHandler(next http.Handler) http.Handler {
 	return handlers.LoggingHandler(os.Stdout, next)
 }
 
+func (h *siteHandlers) adminAuthenticationHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminPassword := h.config.AdminPassword
+		username, password, ok := r.BasicAuth()
+
+		if !ok || adminPassword == "" || username != "admin" ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ancientcitadel"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func ageVerificationHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer next.ServeHTTP(w, r)
@@ -1,141 +1,128 @@
 package ingester
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
-	"strings"
 	"time"
 
 	"github.com/AndrewVos/ancientcitadel/db"
-	"github.com/AndrewVos/ancientcitadel/reddit"
+	"github.com/AndrewVos/ancientcitadel/resolver"
 )
 
-var sfw = []string{
-	"gifs", "perfectloops", "noisygifs", "analogygifs",
-	"reversegif", "aww_gifs", "SlyGifs",
-	"AnimalsBeingJerks", "shittyreactiongifs", "CatGifs",
-	"Puggifs", "SpaceGifs", "physicsgifs", "educationalgifs", "shockwaveporn",
+// Ingest crawls every source on its own schedule until ctx is cancelled.
+func Ingest(ctx context.Context, store db.Store) error {
+	return NewScheduler(store).Run(ctx)
 }
 
-var nsfw = []string{"gifsgonewild", "porn_gifs", "PornGifs", "NSFW_SEXY_GIF",
-	"adultgifs", "NSFW_GIF", "nsfw_gifs", "porngif",
-}
-
-func Ingest() {
-	reddits := map[string]bool{}
-
-	shuffleStrings(sfw)
-	for _, name := range sfw {
-		reddits[name] = false
+func loadSources(ctx context.Context, store db.Store) ([]Source, error) {
+	var sources []Source
+
+	enabled, err := store.GetEnabledSources(ctx)
+	for _, source := range enabled {
+		sources = append(sources, RedditSource{
+			SubReddit:     source.Name,
+			NSFW:          source.NSFW,
+			CrawlInterval: time.Duration(source.CrawlInterval) * time.Second,
+			Sort:          source.Sort,
+			TimeWindow:    source.TimeWindow,
+			Backfill:      source.BackfilledAt == nil,
+		})
 	}
 
-	shuffleStrings(nsfw)
-	for _, name := range nsfw {
-		reddits[name] = true
-	}
+	sources = append(sources, registeredSources...)
+	return sources, err
+}
 
-	go func() {
-		for {
-			for name, nsfw := range reddits {
-				err := updateSubReddit(name, nsfw)
-				if err != nil {
-					log.Println(err)
-				}
+func crawlSource(ctx context.Context, store db.Store, source Source) error {
+	err := updateSource(ctx, store, source)
+	if redditSource, ok := source.(RedditSource); ok {
+		if e := store.MarkSourceCrawled(ctx, redditSource.SubReddit); e != nil {
+			log.Println(e)
+		}
+		if err == nil && redditSource.Backfill {
+			if e := store.MarkSourceBackfilled(ctx, redditSource.SubReddit); e != nil {
+				log.Println(e)
 			}
 		}
-	}()
+	}
+	return err
 }
 
-func updateSubReddit(name string, nsfw bool) error {
-	urlStorer := NewURLStorer()
-	defer urlStorer.Wait()
-
-	subReddit := reddit.SubReddit{Name: name}
-
-	page := 1
-	for pr := range subReddit.AllPages() {
-		fmt.Printf("downloading (nsfw=%v) /r/%v, page %v...\n", nsfw, name, page)
-		page += 1
-		if pr.Error != nil {
-			return pr.Error
+func updateSource(ctx context.Context, store db.Store, source Source) error {
+	return ingestSource(ctx, source, func(url db.URL) bool {
+		id, err := store.ExistsInDB(ctx, url)
+		if err != nil {
+			log.Println(err)
+			return false
 		}
-		for _, redditURL := range pr.RedditURLs {
-			if validGIFURL(redditURL.URL) == false {
-				continue
-			}
-			redditURL.URL = makeValidGIFURL(redditURL.URL)
-			sourceURL := "https://reddit.com" + redditURL.Permalink
-
-			url := db.URL{
-				Title:     redditURL.Title,
-				NSFW:      redditURL.Over18,
-				SourceURL: sourceURL,
-				URL:       redditURL.URL,
-				CreatedAt: time.Unix(int64(redditURL.CreatedUTC), 0),
-			}
-
-			if nsfw != url.NSFW {
-				continue
-			}
 
-			id, err := db.ExistsInDB(url)
-			if err != nil {
+		if id != 0 {
+			if err := store.UpdateURL(ctx, id, url); err != nil {
 				log.Println(err)
-				continue
-			}
-
-			if id != 0 {
-				db.UpdateURL(id, url)
-				continue
 			}
+			return true
+		}
 
-			urlStorer.Upload(&url)
+		result, err := store.GetDownloadResult(ctx, url.URL)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		if result != nil && result.Blocked(time.Now()) {
+			return false
 		}
-	}
-	return nil
-}
 
-func validGIFURL(url string) bool {
-	if strings.HasSuffix(url, ".jpg") {
-		return false
-	}
-	if strings.Contains(url, "youtube.com") {
+		if err := store.EnqueueURL(ctx, url); err != nil {
+			log.Println(err)
+		}
 		return false
-	}
-
-	if strings.Contains(url, "imgur.com") {
-		return true
-	}
-	if strings.Contains(url, "gfycat.com") {
-		return true
-	}
-	if strings.HasSuffix(url, ".gif") {
-		return true
-	}
-	return false
+	})
 }
 
-func makeValidGIFURL(url string) string {
-	if strings.Contains(url, "imgur.com") && !strings.HasSuffix(url, ".gif") {
-		url = strings.TrimSuffix(url, ".gifv")
-		url = strings.TrimSuffix(url, ".webm")
-		url = strings.Replace(url, "http://imgur.com", "http://i.imgur.com", -1)
-		url = strings.Replace(url, "/gallery/", "/", -1)
-		url = url + ".gif"
-	}
-	if strings.Contains(url, "gfycat.com") && !strings.HasSuffix(url, ".gif") {
-		url = strings.Replace(url, "http://gfycat", "http://giant.gfycat", -1)
-		url += ".gif"
+// ingestSource hands each valid url from source to save, which reports
+// whether the url was already known. Paging stops after the first page
+// made up entirely of known urls, since everything after it has been seen,
+// unless the source is backfilling.
+func ingestSource(ctx context.Context, source Source, save func(url db.URL) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	backfilling := false
+	if source, ok := source.(BackfillSource); ok {
+		backfilling = source.Backfilling()
 	}
-	return url
-}
 
-func shuffleStrings(s []string) []string {
-	for i := range s {
-		rand.Seed(time.Now().UnixNano())
-		j := rand.Intn(i + 1)
-		s[i], s[j] = s[j], s[i]
+	page := 1
+	for result := range source.Pages(ctx) {
+		fmt.Printf("downloading %v, page %v...\n", source.Name(), page)
+		page += 1
+		if result.Error != nil {
+			return result.Error
+		}
+
+		valid := 0
+		known := 0
+		for _, url := range result.URLs {
+			media, err := resolver.Resolve(ctx, url.URL)
+			if err != nil {
+				continue
+			}
+			url.URL = media.URL
+			switch media.Type {
+			case resolver.MP4:
+				url.MP4URL = media.URL
+			case resolver.WEBM:
+				url.WEBMURL = media.URL
+			}
+			valid += 1
+			if save(url) {
+				known += 1
+			}
+		}
+		if !backfilling && valid > 0 && known == valid {
+			return nil
+		}
 	}
-	return s
+	return ctx.Err()
 }
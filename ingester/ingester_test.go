@@ -0,0 +1,192 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/reddit"
+)
+
+type fakeSource struct {
+	results []SourceResult
+}
+
+func (s fakeSource) Name() string {
+	return "fake"
+}
+
+func (s fakeSource) Pages(ctx context.Context) chan SourceResult {
+	c := make(chan SourceResult)
+	go func() {
+		defer close(c)
+		for _, result := range s.results {
+			select {
+			case c <- result:
+			case <-ctx.Done():
+				return
+			}
+			if result.Error != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func storedURLs(source Source, known map[string]bool) ([]string, error) {
+	var stored []string
+	err := ingestSource(context.Background(), source, func(url db.URL) bool {
+		stored = append(stored, url.URL)
+		return known[url.URL]
+	})
+	return stored, err
+}
+
+func TestIngestSourceStoresValidURLs(t *testing.T) {
+	source := fakeSource{results: []SourceResult{
+		{URLs: []db.URL{
+			{URL: "http://example.com/a.gif"},
+			{URL: "http://example.com/b.jpg"},
+		}},
+		{URLs: []db.URL{
+			{URL: "http://imgur.com/Zx9sVdz.gifv"},
+			{URL: "https://www.youtube.com/watch?v=123"},
+		}},
+	}}
+
+	actual, err := storedURLs(source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"http://example.com/a.gif", "https://i.imgur.com/Zx9sVdz.mp4"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestIngestSourceReturnsErrors(t *testing.T) {
+	sourceError := errors.New("source went away")
+	source := fakeSource{results: []SourceResult{
+		{URLs: []db.URL{{URL: "http://example.com/a.gif"}}},
+		{Error: sourceError},
+		{URLs: []db.URL{{URL: "http://example.com/b.gif"}}},
+	}}
+
+	actual, err := storedURLs(source, nil)
+	if err != sourceError {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", sourceError, err)
+	}
+	expected := []string{"http://example.com/a.gif"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestIngestSourceStopsAtKnownPage(t *testing.T) {
+	source := fakeSource{results: []SourceResult{
+		{URLs: []db.URL{{URL: "http://example.com/new.gif"}, {URL: "http://example.com/a.gif"}}},
+		{URLs: []db.URL{{URL: "http://example.com/b.gif"}, {URL: "http://example.com/b.jpg"}}},
+		{URLs: []db.URL{{URL: "http://example.com/c.gif"}}},
+	}}
+	known := map[string]bool{
+		"http://example.com/a.gif": true,
+		"http://example.com/b.gif": true,
+	}
+
+	actual, err := storedURLs(source, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"http://example.com/new.gif",
+		"http://example.com/a.gif",
+		"http://example.com/b.gif",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestIngestSourceKeepsVideoAsVideo(t *testing.T) {
+	source := fakeSource{results: []SourceResult{
+		{URLs: []db.URL{
+			{URL: "http://example.com/a.gif"},
+			{URL: "http://i.imgur.com/Zx9sVdz.gifv"},
+			{URL: "http://example.com/b.webm"},
+		}},
+	}}
+
+	var actual []db.URL
+	err := ingestSource(context.Background(), source, func(url db.URL) bool {
+		actual = append(actual, url)
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []db.URL{
+		{URL: "http://example.com/a.gif"},
+		{URL: "https://i.imgur.com/Zx9sVdz.mp4", MP4URL: "https://i.imgur.com/Zx9sVdz.mp4"},
+		{URL: "http://example.com/b.webm", WEBMURL: "http://example.com/b.webm"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+type backfillingSource struct {
+	fakeSource
+}
+
+func (s backfillingSource) Backfilling() bool {
+	return true
+}
+
+func TestIngestSourceKeepsPagingWhileBackfilling(t *testing.T) {
+	source := backfillingSource{fakeSource{results: []SourceResult{
+		{URLs: []db.URL{{URL: "http://example.com/a.gif"}}},
+		{URLs: []db.URL{{URL: "http://example.com/b.gif"}}},
+	}}}
+	known := map[string]bool{"http://example.com/a.gif": true}
+
+	actual, err := storedURLs(source, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"http://example.com/a.gif", "http://example.com/b.gif"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
+
+func TestRedditURLsToURLs(t *testing.T) {
+	redditURLs := []reddit.RedditURL{
+		{
+			ID: "a", SubReddit: "gifs", Author: "someone", Score: 10, NumComments: 2, LinkFlairText: "OC",
+			Title: "safe", URL: "http://example.com/a.gif", Permalink: "/r/gifs/comments/a/safe/", CreatedUTC: 1434326400,
+		},
+		{Title: "unsafe", URL: "http://example.com/b.gif", Permalink: "/r/gifs/comments/b/unsafe/", CreatedUTC: 1434326400, Over18: true},
+	}
+
+	actual := redditURLsToURLs(redditURLs, false)
+	expected := []db.URL{{
+		Title:       "safe",
+		NSFW:        false,
+		SourceURL:   "https://reddit.com/r/gifs/comments/a/safe/",
+		URL:         "http://example.com/a.gif",
+		CreatedAt:   time.Unix(1434326400, 0),
+		RedditID:    "a",
+		SubReddit:   "gifs",
+		Author:      "someone",
+		Score:       10,
+		NumComments: 2,
+		Flair:       "OC",
+	}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, actual)
+	}
+}
@@ -0,0 +1,66 @@
+package ingester
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/gifs"
+	"github.com/AndrewVos/ancientcitadel/storage"
+)
+
+var mediaClient = &http.Client{Timeout: 5 * time.Minute}
+
+// persistMedia copies the video and thumbnail of url into store, pointing
+// url at the copies.
+func persistMedia(ctx context.Context, store storage.Store, url *db.URL) error {
+	for _, mediaURL := range []*string{&url.MP4URL, &url.WEBMURL, &url.ThumbnailURL} {
+		if *mediaURL == "" || strings.HasPrefix(*mediaURL, store.URL("")) {
+			continue
+		}
+
+		copied, err := copyMedia(ctx, store, *mediaURL)
+		if err != nil {
+			return err
+		}
+		*mediaURL = copied
+	}
+	return nil
+}
+
+func copyMedia(ctx context.Context, store storage.Store, mediaURL string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", mediaURL, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := mediaClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return "", &gifs.StatusError{URL: mediaURL, StatusCode: response.StatusCode}
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	extension := strings.ToLower(path.Ext(strings.SplitN(mediaURL, "?", 2)[0]))
+	if extension == "" {
+		if extensions, _ := mime.ExtensionsByType(contentType); len(extensions) > 0 {
+			extension = extensions[0]
+		}
+	}
+
+	name := fmt.Sprintf("%x%v", sha1.Sum([]byte(mediaURL)), extension)
+	fmt.Printf("copying %q to %q...\n", mediaURL, name)
+	err = store.Put(name, response.Body, contentType)
+	if err != nil {
+		return "", err
+	}
+	return store.URL(name), nil
+}
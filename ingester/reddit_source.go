@@ -0,0 +1,79 @@
+package ingester
+
+import (
+	"context"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/reddit"
+)
+
+type RedditSource struct {
+	SubReddit     string
+	NSFW          bool
+	CrawlInterval time.Duration
+	Sort          string
+	TimeWindow    string
+	// Backfill walks the all time top listing instead of Sort.
+	Backfill bool
+}
+
+func (s RedditSource) Name() string {
+	return "/r/" + s.SubReddit
+}
+
+func (s RedditSource) Interval() time.Duration {
+	return s.CrawlInterval
+}
+
+func (s RedditSource) Backfilling() bool {
+	return s.Backfill
+}
+
+func (s RedditSource) Pages(ctx context.Context) chan SourceResult {
+	c := make(chan SourceResult)
+	go func() {
+		defer close(c)
+		subReddit := reddit.SubReddit{Name: s.SubReddit, Sort: s.Sort, TimeWindow: s.TimeWindow}
+		if s.Backfill {
+			subReddit.Sort = reddit.Top
+			subReddit.TimeWindow = "all"
+		}
+		for {
+			redditURLs, err := subReddit.NextPage(ctx)
+			select {
+			case c <- SourceResult{URLs: redditURLsToURLs(redditURLs, s.NSFW), Error: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil || len(redditURLs) == 0 {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func redditURLsToURLs(redditURLs []reddit.RedditURL, nsfw bool) []db.URL {
+	var urls []db.URL
+	for _, redditURL := range redditURLs {
+		if redditURL.Over18 != nsfw {
+			continue
+		}
+		urls = append(urls, db.URL{
+			Title:       redditURL.Title,
+			NSFW:        redditURL.Over18,
+			SourceURL:   "https://reddit.com" + redditURL.Permalink,
+			URL:         redditURL.URL,
+			CreatedAt:   time.Unix(int64(redditURL.CreatedUTC), 0),
+			RedditID:    redditURL.ID,
+			SubReddit:   redditURL.SubReddit,
+			Author:      redditURL.Author,
+			Score:       redditURL.Score,
+			NumComments: redditURL.NumComments,
+			Flair:       redditURL.LinkFlairText,
+			Spoiler:     redditURL.Spoiler,
+		})
+	}
+	return urls
+}
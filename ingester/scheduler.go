@@ -0,0 +1,155 @@
+package ingester
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+)
+
+// Clock is the source of time for a Scheduler, so that tests can control it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Scheduler crawls sources whenever they are due, backing off
+// exponentially from sources that keep failing.
+type Scheduler struct {
+	// Interval is how long to wait between successful crawls of a source
+	// that doesn't implement IntervalSource.
+	Interval time.Duration
+	// MinBackoff and MaxBackoff bound the wait after a failed crawl,
+	// which doubles with each consecutive failure.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// ReloadInterval is the longest the scheduler sleeps before reloading
+	// sources, so that newly added sources are picked up.
+	ReloadInterval time.Duration
+
+	Clock   Clock
+	Sources func(ctx context.Context) ([]Source, error)
+	Crawl   func(ctx context.Context, source Source) error
+
+	schedules map[string]*schedule
+}
+
+type schedule struct {
+	nextRun  time.Time
+	failures int
+}
+
+func NewScheduler(store db.Store) *Scheduler {
+	return &Scheduler{
+		Interval:       15 * time.Minute,
+		MinBackoff:     time.Minute,
+		MaxBackoff:     6 * time.Hour,
+		ReloadInterval: time.Minute,
+		Clock:          realClock{},
+		Sources: func(ctx context.Context) ([]Source, error) {
+			return loadSources(ctx, store)
+		},
+		Crawl: func(ctx context.Context, source Source) error {
+			return crawlSource(ctx, store, source)
+		},
+	}
+}
+
+// Run crawls due sources until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		next := s.runDue(ctx)
+
+		wait := next.Sub(s.Clock.Now())
+		if wait > s.ReloadInterval {
+			wait = s.ReloadInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.Clock.After(wait):
+		}
+	}
+}
+
+// runDue crawls every source whose next run has arrived and returns the
+// time at which the next source will be due.
+func (s *Scheduler) runDue(ctx context.Context) time.Time {
+	if s.schedules == nil {
+		s.schedules = map[string]*schedule{}
+	}
+
+	sources, err := s.Sources(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+
+	next := s.Clock.Now().Add(s.ReloadInterval)
+	seen := map[string]bool{}
+	for _, source := range sources {
+		name := source.Name()
+		seen[name] = true
+
+		sched, ok := s.schedules[name]
+		if !ok {
+			sched = &schedule{nextRun: s.Clock.Now()}
+			s.schedules[name] = sched
+		}
+
+		if !sched.nextRun.After(s.Clock.Now()) {
+			if ctx.Err() != nil {
+				return next
+			}
+			err := s.Crawl(ctx, source)
+			if err != nil {
+				sched.failures += 1
+				sched.nextRun = s.Clock.Now().Add(s.backoff(sched.failures))
+				log.Printf("crawling %v failed %d times, retrying at %v: %v\n", name, sched.failures, sched.nextRun, err)
+			} else {
+				sched.failures = 0
+				sched.nextRun = s.Clock.Now().Add(s.interval(source))
+			}
+		}
+
+		if sched.nextRun.Before(next) {
+			next = sched.nextRun
+		}
+	}
+
+	for name := range s.schedules {
+		if !seen[name] {
+			delete(s.schedules, name)
+		}
+	}
+	return next
+}
+
+func (s *Scheduler) interval(source Source) time.Duration {
+	if source, ok := source.(IntervalSource); ok && source.Interval() > 0 {
+		return source.Interval()
+	}
+	return s.Interval
+}
+
+func (s *Scheduler) backoff(failures int) time.Duration {
+	backoff := s.MinBackoff
+	for i := 1; i < failures; i++ {
+		backoff *= 2
+		if backoff >= s.MaxBackoff {
+			return s.MaxBackoff
+		}
+	}
+	return backoff
+}
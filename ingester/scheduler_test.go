@@ -0,0 +1,165 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now     time.Time
+	waiting chan time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	if c.waiting != nil {
+		c.waiting <- d
+	}
+	return make(chan time.Time)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+type namedSource struct {
+	name     string
+	interval time.Duration
+}
+
+func (s namedSource) Name() string {
+	return s.name
+}
+
+func (s namedSource) Pages(ctx context.Context) chan SourceResult {
+	c := make(chan SourceResult)
+	close(c)
+	return c
+}
+
+func (s namedSource) Interval() time.Duration {
+	return s.interval
+}
+
+func newTestScheduler(clock *fakeClock, sources []Source, failing map[string]bool) (*Scheduler, *[]string) {
+	var crawled []string
+	scheduler := NewScheduler(nil)
+	scheduler.Clock = clock
+	scheduler.ReloadInterval = 24 * time.Hour
+	scheduler.Sources = func(ctx context.Context) ([]Source, error) {
+		return sources, nil
+	}
+	scheduler.Crawl = func(ctx context.Context, source Source) error {
+		crawled = append(crawled, source.Name())
+		if failing[source.Name()] {
+			return errors.New("crawl failed")
+		}
+		return nil
+	}
+	return scheduler, &crawled
+}
+
+func TestSchedulerCrawlsSourcesWhenDue(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 23, 0, 0, 0, 0, time.UTC)}
+	sources := []Source{
+		namedSource{name: "default"},
+		namedSource{name: "fast", interval: 5 * time.Minute},
+	}
+	scheduler, crawled := newTestScheduler(clock, sources, nil)
+
+	type step struct {
+		Advance  time.Duration
+		Expected []string
+	}
+	steps := []step{
+		{Advance: 0, Expected: []string{"default", "fast"}},
+		{Advance: time.Minute, Expected: nil},
+		{Advance: 4 * time.Minute, Expected: []string{"fast"}},
+		{Advance: 10 * time.Minute, Expected: []string{"default", "fast"}},
+	}
+
+	for _, step := range steps {
+		*crawled = nil
+		clock.Advance(step.Advance)
+		scheduler.runDue(context.Background())
+		if !reflect.DeepEqual(*crawled, step.Expected) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", step.Expected, *crawled)
+		}
+	}
+}
+
+func TestSchedulerBacksOffFailingSources(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 23, 0, 0, 0, 0, time.UTC)}
+	sources := []Source{namedSource{name: "broken"}}
+	scheduler, _ := newTestScheduler(clock, sources, map[string]bool{"broken": true})
+	scheduler.MaxBackoff = 5 * time.Minute
+
+	expected := []time.Duration{
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		5 * time.Minute,
+		5 * time.Minute,
+	}
+	for _, backoff := range expected {
+		started := clock.Now()
+		next := scheduler.runDue(context.Background())
+		actual := next.Sub(started)
+		if actual != backoff {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", backoff, actual)
+		}
+		clock.Advance(actual)
+	}
+}
+
+func TestSchedulerResetsBackoffAfterSuccess(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 23, 0, 0, 0, 0, time.UTC)}
+	failing := map[string]bool{"flaky": true}
+	scheduler, _ := newTestScheduler(clock, []Source{namedSource{name: "flaky"}}, failing)
+
+	next := scheduler.runDue(context.Background())
+	clock.Advance(next.Sub(clock.Now()))
+	next = scheduler.runDue(context.Background())
+	clock.Advance(next.Sub(clock.Now()))
+
+	failing["flaky"] = false
+	scheduler.runDue(context.Background())
+	if failures := scheduler.schedules["flaky"].failures; failures != 0 {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", 0, failures)
+	}
+}
+
+func TestSchedulerStopsWhenCancelled(t *testing.T) {
+	clock := &fakeClock{
+		now:     time.Date(2015, 6, 23, 0, 0, 0, 0, time.UTC),
+		waiting: make(chan time.Duration),
+	}
+	scheduler, _ := newTestScheduler(clock, []Source{namedSource{name: "default"}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- scheduler.Run(ctx)
+	}()
+
+	wait := <-clock.waiting
+	if wait != scheduler.Interval {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", scheduler.Interval, wait)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("scheduler didn't stop after being cancelled")
+	}
+}
@@ -0,0 +1,43 @@
+package ingester
+
+import (
+	"context"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+)
+
+// Source is anywhere that candidate gifs can be ingested from.
+// Pages yields results until the source is exhausted, an error occurs
+// or ctx is cancelled, at which point the channel is closed.
+type Source interface {
+	Name() string
+	Pages(ctx context.Context) chan SourceResult
+}
+
+// IntervalSource is a Source that wants to be crawled on its own schedule
+// rather than the scheduler's default interval.
+type IntervalSource interface {
+	Source
+	Interval() time.Duration
+}
+
+// BackfillSource is a Source that may be walking its whole history, in
+// which case paging carries on past pages of urls that are already known.
+type BackfillSource interface {
+	Source
+	Backfilling() bool
+}
+
+type SourceResult struct {
+	URLs  []db.URL
+	Error error
+}
+
+var registeredSources []Source
+
+// RegisterSource adds a source to be ingested alongside the subreddits
+// stored in the sources table.
+func RegisterSource(source Source) {
+	registeredSources = append(registeredSources, source)
+}
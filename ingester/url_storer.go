@@ -1,42 +1,113 @@
 package ingester
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"sync"
+	"net/http"
+	"time"
 
 	"github.com/AndrewVos/ancientcitadel/db"
 	"github.com/AndrewVos/ancientcitadel/gifs"
 )
 
-type URLStorer struct {
-	urls      chan *db.URL
-	waitGroup sync.WaitGroup
+func (w *Worker) storeURL(ctx context.Context, transcoder gifs.Transcoder, url *db.URL) error {
+	result, err := w.Store.GetDownloadResult(ctx, url.URL)
+	if err != nil {
+		return err
+	}
+	if result != nil && result.Blocked(time.Now()) {
+		if result.Permanent {
+			return nil
+		}
+		// It was retried too early, which can happen when the clock here
+		// is behind the database's.
+		return &retryError{err: errors.New(result.Error), retryAt: *result.RetryAt}
+	}
+
+	if url.MP4URL != "" || url.WEBMURL != "" {
+		err = probeVideo(ctx, gifs.FFprobe(w.FFmpeg), url)
+		if err == nil && url.ThumbnailURL == "" && w.ThumbnailStore != nil {
+			url.ThumbnailURL, err = gifs.Thumbnail(ctx, gifs.FFmpeg(w.FFmpeg), w.ThumbnailStore, url.URL)
+		}
+	} else {
+		err = transcodeGif(ctx, transcoder, url)
+	}
+	if err == nil && w.MediaStore != nil {
+		err = persistMedia(ctx, w.MediaStore, url)
+	}
+	if err != nil && ctx.Err() != nil {
+		// The worker is shutting down, which says nothing about the url.
+		return err
+	}
+	if err != nil {
+		if e := w.Store.StoreDownloadFailure(ctx, *url, err.Error(), permanentFailure(err), DownloadBackoff); e != nil {
+			log.Printf("couldn't store download result because: %v\n", e)
+			return err
+		}
+		result, e := w.Store.GetDownloadResult(ctx, url.URL)
+		if e != nil {
+			log.Printf("couldn't get download result because: %v\n", e)
+			return err
+		}
+		if result != nil && !result.Permanent && result.RetryAt != nil {
+			return &retryError{err: err, retryAt: *result.RetryAt}
+		}
+		return err
+	}
+	if e := w.Store.StoreDownloadSuccess(ctx, url.URL); e != nil {
+		log.Printf("couldn't store download result because: %v\n", e)
+	}
+	return w.Store.SaveURL(ctx, url)
 }
 
-func (g *URLStorer) Upload(url *db.URL) {
-	g.urls <- url
+// retryError is a transient download failure, after which the job should
+// run again at retryAt.
+type retryError struct {
+	err     error
+	retryAt time.Time
 }
 
-func (g *URLStorer) Wait() {
-	close(g.urls)
-	g.waitGroup.Wait()
+func (e *retryError) Error() string {
+	return e.err.Error()
 }
 
-func storeURL(host string, url *db.URL) error {
-	result, err := db.GetDownloadResult(url.URL)
+// DownloadBackoff is how long to wait before retrying a url after its
+// first transient failure.
+var DownloadBackoff = 10 * time.Minute
+
+// permanentFailure reports whether err means there's no point ever
+// trying to download a url again. Timeouts, server errors and anything
+// unexpected are assumed to be transient.
+func permanentFailure(err error) bool {
+	switch err := err.(type) {
+	case *gifs.StatusError:
+		return err.StatusCode >= 400 && err.StatusCode < 500 &&
+			err.StatusCode != http.StatusRequestTimeout &&
+			err.StatusCode != http.StatusTooManyRequests
+	case *gifs.TranscodeError:
+		return true
+	}
+	return false
+}
+
+// probeVideo fills in the dimensions of urls that are already video,
+// which don't need to go anywhere near the transcoder.
+func probeVideo(ctx context.Context, ffprobe string, url *db.URL) error {
+	fmt.Printf("probing %q...\n", url.URL)
+	width, height, err := gifs.Probe(ctx, ffprobe, url.URL)
 	if err != nil {
 		return err
 	}
-	if result != nil && result.Success == false {
-		return nil
-	}
+	url.Width = width
+	url.Height = height
+	return nil
+}
 
-	fmt.Printf("uploading %q to %q...\n", url.URL, host)
-	information, err := gifs.Gif(host, url.URL)
-	if e := db.StoreDownloadResult(url.URL, err == nil); e != nil {
-		log.Printf("couldn't store download result because: %v\n", e)
-	}
+func transcodeGif(ctx context.Context, transcoder gifs.Transcoder, url *db.URL) error {
+	fmt.Printf("transcoding %q with %v...\n", url.URL, transcoder)
+	information, err := transcoder.Transcode(ctx, url.URL)
 	if err != nil {
 		return err
 	}
@@ -46,25 +117,5 @@ func storeURL(host string, url *db.URL) error {
 	url.ThumbnailURL = information.JPGURL
 	url.Width = information.Width
 	url.Height = information.Height
-	return db.SaveURL(url)
-}
-
-func NewURLStorer() *URLStorer {
-	uploader := &URLStorer{urls: make(chan *db.URL)}
-
-	processors := 5
-	for i := 1; i <= processors; i++ {
-		uploader.waitGroup.Add(1)
-		go func(processor int) {
-			host := fmt.Sprintf("http://gifs%v.ancientcitadel.com", processor)
-			for url := range uploader.urls {
-				err := storeURL(host, url)
-				if err != nil {
-					log.Println(err)
-				}
-			}
-			uploader.waitGroup.Done()
-		}(i)
-	}
-	return uploader
+	return nil
 }
@@ -0,0 +1,71 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/gifs"
+)
+
+func TestPermanentFailure(t *testing.T) {
+	type failureExample struct {
+		Error    error
+		Expected bool
+	}
+	examples := []failureExample{
+		{Error: &gifs.StatusError{StatusCode: 404}, Expected: true},
+		{Error: &gifs.StatusError{StatusCode: 410}, Expected: true},
+		{Error: &gifs.StatusError{StatusCode: 403}, Expected: true},
+		{Error: &gifs.StatusError{StatusCode: 408}, Expected: false},
+		{Error: &gifs.StatusError{StatusCode: 429}, Expected: false},
+		{Error: &gifs.StatusError{StatusCode: 500}, Expected: false},
+		{Error: &gifs.StatusError{StatusCode: 503}, Expected: false},
+		{Error: &gifs.TranscodeError{Reason: "not a gif"}, Expected: true},
+		{Error: &net.OpError{Op: "dial", Err: errors.New("i/o timeout")}, Expected: false},
+		{Error: errors.New("something unexpected"), Expected: false},
+	}
+
+	for _, example := range examples {
+		actual := permanentFailure(example.Error)
+		if actual != example.Expected {
+			t.Errorf("Expected %v to be permanent=%v, got %v", example.Error, example.Expected, actual)
+		}
+	}
+}
+
+type failingTranscoder struct {
+	err error
+}
+
+func (t failingTranscoder) Transcode(ctx context.Context, gifURL string) (gifs.GifInformation, error) {
+	return gifs.GifInformation{}, t.err
+}
+
+func TestStoreURLRetriesTransientFailures(t *testing.T) {
+	type retryExample struct {
+		Error         error
+		ExpectedRetry bool
+	}
+	examples := []retryExample{
+		{Error: &gifs.StatusError{StatusCode: 503}, ExpectedRetry: true},
+		{Error: &gifs.StatusError{StatusCode: 404}, ExpectedRetry: false},
+	}
+
+	for _, example := range examples {
+		worker := NewWorker(db.NewMemory(), nil, nil, 1)
+		before := time.Now()
+		err := worker.storeURL(context.Background(), failingTranscoder{example.Error}, &db.URL{URL: "http://i.imgur.com/a.gif"})
+
+		retry, ok := err.(*retryError)
+		if ok != example.ExpectedRetry {
+			t.Errorf("Expected %v to be retried=%v, got %v", example.Error, example.ExpectedRetry, ok)
+		}
+		if ok && retry.retryAt.Before(before.Add(DownloadBackoff)) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", before.Add(DownloadBackoff), retry.retryAt)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package ingester
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/gifs"
+	"github.com/AndrewVos/ancientcitadel/storage"
+)
+
+// Worker drains the job queue, transcoding and storing each queued gif.
+type Worker struct {
+	Store db.Store
+	// Transcoders are used to turn gifs into video, shared between the
+	// goroutines.
+	Transcoders []gifs.Transcoder
+	// MediaStore keeps copies of every video and thumbnail so that pages
+	// don't break when the original hosts delete them. Media is hotlinked
+	// when nil.
+	MediaStore storage.Store
+	// ThumbnailStore is where thumbnails are kept for gifs that come as
+	// video, since there's no transcode to make one. They go without
+	// thumbnails when it's nil.
+	ThumbnailStore storage.Store
+	// FFmpeg is the ffmpeg binary used for gifs that come as video, found
+	// on $PATH by default. ffprobe is expected to be alongside it.
+	FFmpeg string
+
+	Concurrency int
+	// VisibilityTimeout is how long a job can go without being extended
+	// before it's handed to another worker. Running jobs are extended every
+	// third of it, so it only runs out when a worker dies.
+	VisibilityTimeout time.Duration
+	// PollInterval is how long idle goroutines wait before checking the
+	// queue again.
+	PollInterval time.Duration
+	// FinishedJobRetention is how long finished jobs are kept around for.
+	FinishedJobRetention time.Duration
+}
+
+func NewWorker(store db.Store, transcoders []gifs.Transcoder, mediaStore storage.Store, concurrency int) *Worker {
+	return &Worker{
+		Store:                store,
+		Transcoders:          transcoders,
+		MediaStore:           mediaStore,
+		Concurrency:          concurrency,
+		VisibilityTimeout:    15 * time.Minute,
+		PollInterval:         5 * time.Second,
+		FinishedJobRetention: 24 * time.Hour,
+	}
+}
+
+// Run drains the job queue with Concurrency goroutines until ctx is
+// cancelled. Jobs that are still running are stopped and put back on the
+// queue before Run returns.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func(transcoder gifs.Transcoder) {
+			defer wg.Done()
+			w.work(ctx, transcoder)
+		}(w.Transcoders[i%len(w.Transcoders)])
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			if err := w.Store.ReapJobs(ctx, time.Now().Add(-w.FinishedJobRetention)); err != nil {
+				log.Println(err)
+			}
+			if err := w.Store.ReapViewCounts(ctx); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Hour):
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+func (w *Worker) work(ctx context.Context, transcoder gifs.Transcoder) {
+	for ctx.Err() == nil {
+		job, err := w.Store.ClaimJob(ctx, w.VisibilityTimeout)
+		if err != nil {
+			log.Println(err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.PollInterval):
+			}
+			continue
+		}
+
+		jobCtx, cancelJob := context.WithCancel(ctx)
+		lost := false
+		held := make(chan struct{})
+		go func() {
+			defer close(held)
+			w.hold(jobCtx, job, func() {
+				lost = true
+				cancelJob()
+			})
+		}()
+		err = w.runJob(jobCtx, transcoder, job)
+		cancelJob()
+		<-held
+
+		if lost {
+			// Another worker has the job now, so it's theirs to finish.
+			continue
+		}
+		if err != nil && ctx.Err() != nil {
+			// Transcodes can take minutes, so rather than hold up shutting
+			// down the job is cut short and goes back on the queue for
+			// another worker.
+			if err := w.Store.ReleaseJob(context.Background(), job.ID); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+
+		// A job that finished just as ctx was cancelled still has to be
+		// marked as finished.
+		if retry, ok := err.(*retryError); ok {
+			log.Printf("%v, retrying at %v\n", err, retry.retryAt)
+			err = w.Store.RetryJob(context.Background(), job.ID, err.Error(), retry.retryAt)
+		} else if err != nil {
+			log.Println(err)
+			err = w.Store.FailJob(context.Background(), job.ID, err.Error())
+		} else {
+			err = w.Store.CompleteJob(context.Background(), job.ID)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// hold extends job every third of VisibilityTimeout until ctx is
+// cancelled, so that long transcodes aren't handed to another worker. It
+// calls lost if another worker has taken job over anyway.
+func (w *Worker) hold(ctx context.Context, job *db.Job, lost func()) {
+	ticker := time.NewTicker(w.VisibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		held, err := w.Store.ExtendJob(ctx, job.ID, job.Attempts, w.VisibilityTimeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println(err)
+			}
+			continue
+		}
+		if !held {
+			log.Printf("job %v was handed to another worker\n", job.ID)
+			lost()
+			return
+		}
+	}
+}
+
+func (w *Worker) runJob(ctx context.Context, transcoder gifs.Transcoder, job *db.Job) error {
+	url, err := job.DecodeURL()
+	if err != nil {
+		return err
+	}
+	id, err := w.Store.ExistsInDB(ctx, url)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		return nil
+	}
+	return w.storeURL(ctx, transcoder, &url)
+}
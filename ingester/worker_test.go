@@ -0,0 +1,61 @@
+package ingester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/gifs"
+)
+
+type slowTranscoder struct {
+	duration time.Duration
+}
+
+func (t slowTranscoder) Transcode(ctx context.Context, gifURL string) (gifs.GifInformation, error) {
+	select {
+	case <-ctx.Done():
+		return gifs.GifInformation{}, ctx.Err()
+	case <-time.After(t.duration):
+	}
+	return gifs.GifInformation{}, &gifs.TranscodeError{Reason: "not a gif"}
+}
+
+func TestWorkerHoldsLongJobs(t *testing.T) {
+	store := db.NewMemory()
+	store.EnqueueURL(context.Background(), db.URL{URL: "http://example.com/a.gif"})
+
+	worker := NewWorker(store, []gifs.Transcoder{slowTranscoder{300 * time.Millisecond}}, nil, 1)
+	worker.VisibilityTimeout = 60 * time.Millisecond
+	worker.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(stopped)
+	}()
+
+	// The job has been running for longer than VisibilityTimeout, but it's
+	// been extended so it isn't handed out again.
+	time.Sleep(150 * time.Millisecond)
+	if job, _ := store.ClaimJob(context.Background(), time.Minute); job != nil {
+		t.Errorf("Expected the running job to be held\nGot:\n%+v\n", job)
+	}
+	if held, _ := store.ExtendJob(context.Background(), 1, 0, time.Minute); held {
+		t.Errorf("Expected an old attempt not to extend the job")
+	}
+
+	var failed []db.Job
+	for i := 0; i < 100 && len(failed) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		failed, _ = store.GetJobs(context.Background(), db.JobFailed, 1, 10)
+	}
+	cancel()
+	<-stopped
+
+	if len(failed) != 1 || failed[0].Attempts != 1 {
+		t.Errorf("Expected the job to fail once\nGot:\n%+v\n", failed)
+	}
+}
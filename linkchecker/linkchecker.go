@@ -0,0 +1,202 @@
+package linkchecker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+)
+
+// Checker revalidates the media of stored urls on a rolling schedule,
+// so that urls whose links keep failing are hidden from listings.
+type Checker struct {
+	Store db.Store
+	// BaseURL resolves relative links, like media served from /media.
+	BaseURL string
+	Client  *http.Client
+	// Interval is how long to wait between batches.
+	Interval time.Duration
+	// RecheckAfter is how long a url goes between checks.
+	RecheckAfter time.Duration
+	// RetryFailingAfter is how long a url whose links have started failing
+	// goes between checks, until it's either working again or hidden.
+	RetryFailingAfter time.Duration
+	BatchSize         int
+	Concurrency       int
+}
+
+func NewChecker(store db.Store, baseURL string) *Checker {
+	return &Checker{
+		Store:             store,
+		BaseURL:           baseURL,
+		Client:            &http.Client{Timeout: 30 * time.Second},
+		Interval:          time.Minute,
+		RecheckAfter:      7 * 24 * time.Hour,
+		RetryFailingAfter: time.Hour,
+		BatchSize:         100,
+		Concurrency:       5,
+	}
+}
+
+// Run checks a batch of urls every Interval until ctx is cancelled.
+func (c *Checker) Run(ctx context.Context) error {
+	for {
+		err := c.checkBatch(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+func (c *Checker) checkBatch(ctx context.Context) error {
+	now := time.Now()
+	urls, err := c.Store.GetURLsToCheck(ctx, now.Add(-c.RecheckAfter), now.Add(-c.RetryFailingAfter), c.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	work := make(chan db.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range work {
+				status, outcome := c.Check(ctx, url)
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := c.Store.StoreLinkCheck(ctx, url.ID, status, outcome); err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	}
+
+	for _, url := range urls {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- url
+	}
+	close(work)
+	wg.Wait()
+	return nil
+}
+
+// forbiddenWhenGone are hosts that answer 403 rather than 404 for media
+// that's been deleted.
+var forbiddenWhenGone = map[string]bool{
+	"giant.gfycat.com":  true,
+	"thumbs.gfycat.com": true,
+	"i.redd.it":         true,
+	"v.redd.it":         true,
+}
+
+// Check requests every link of url that's served, returning the status of
+// the first link that's broken, or 200 when they all work. Only a 404, a
+// 410 or a 403 from a host that means gone by it counts as broken; any
+// other failure is inconclusive, since the host may only be down for now.
+// The original link is only checked when there's no video, since pages
+// show the video, which may well be a copy that outlives the original.
+func (c *Checker) Check(ctx context.Context, u db.URL) (int, string) {
+	links := []string{u.MP4URL, u.WEBMURL, u.ThumbnailURL}
+	if u.MP4URL == "" && u.WEBMURL == "" {
+		links = append(links, u.URL)
+	}
+	status, outcome := http.StatusOK, db.LinkWorking
+	for _, link := range links {
+		if link == "" {
+			continue
+		}
+		s, err := c.checkLink(ctx, link)
+		if err == nil {
+			continue
+		}
+		log.Printf("checking %q failed: %v\n", link, err)
+		if gone(link, s) {
+			return s, db.LinkBroken
+		}
+		if outcome == db.LinkWorking {
+			status, outcome = s, db.LinkInconclusive
+		}
+	}
+	return status, outcome
+}
+
+func gone(link string, status int) bool {
+	switch status {
+	case http.StatusNotFound, http.StatusGone:
+		return true
+	case http.StatusForbidden:
+		u, err := url.Parse(link)
+		return err == nil && forbiddenWhenGone[u.Hostname()]
+	}
+	return false
+}
+
+// checkLink makes a HEAD request for link, falling back to fetching a
+// single byte for servers that don't allow HEAD. A zero status means the
+// request didn't get a response at all.
+func (c *Checker) checkLink(ctx context.Context, link string) (int, error) {
+	target, err := c.resolve(link)
+	if err != nil {
+		return 0, err
+	}
+
+	status, err := c.request(ctx, "HEAD", target)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.request(ctx, "GET", target)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status >= 400 {
+		return status, fmt.Errorf("http status was %d", status)
+	}
+	return status, nil
+}
+
+func (c *Checker) request(ctx context.Context, method string, target string) (int, error) {
+	request, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	request = request.WithContext(ctx)
+	if method == "GET" {
+		request.Header.Set("Range", "bytes=0-0")
+	}
+
+	response, err := c.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+func (c *Checker) resolve(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return link, nil
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(u).String(), nil
+}
@@ -0,0 +1,131 @@
+package linkchecker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.mp4", "/media/ok.mp4", "/media/ok.jpg":
+			w.WriteHeader(http.StatusOK)
+		case "/no-head.webm":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			} else if r.Header.Get("Range") == "bytes=0-0" {
+				w.WriteHeader(http.StatusPartialContent)
+			}
+		case "/moved.gif":
+			http.Redirect(w, r, "/ok.mp4", http.StatusMovedPermanently)
+		case "/broken.gif":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/down.mp4":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/deleted.gif":
+			w.WriteHeader(http.StatusGone)
+		case "/private.gif":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	checker := NewChecker(nil, server.URL)
+
+	type checkExample struct {
+		URL             db.URL
+		ExpectedStatus  int
+		ExpectedOutcome string
+	}
+	examples := []checkExample{
+		{
+			URL:             db.URL{URL: server.URL + "/moved.gif", MP4URL: server.URL + "/ok.mp4", WEBMURL: server.URL + "/no-head.webm", ThumbnailURL: "/media/ok.jpg"},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedOutcome: db.LinkWorking,
+		},
+		{
+			URL:             db.URL{URL: server.URL + "/ok.mp4", MP4URL: server.URL + "/gone.mp4"},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedOutcome: db.LinkBroken,
+		},
+		{
+			URL:             db.URL{URL: server.URL + "/gone.gif", MP4URL: "/media/ok.mp4"},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedOutcome: db.LinkWorking,
+		},
+		{
+			URL:             db.URL{URL: server.URL + "/broken.gif"},
+			ExpectedStatus:  http.StatusInternalServerError,
+			ExpectedOutcome: db.LinkInconclusive,
+		},
+		{
+			URL:             db.URL{URL: server.URL + "/deleted.gif"},
+			ExpectedStatus:  http.StatusGone,
+			ExpectedOutcome: db.LinkBroken,
+		},
+		{
+			URL:             db.URL{URL: server.URL + "/private.gif"},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedOutcome: db.LinkInconclusive,
+		},
+		{
+			URL:             db.URL{URL: server.URL + "/ok.mp4", MP4URL: server.URL + "/down.mp4", ThumbnailURL: server.URL + "/gone.jpg"},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedOutcome: db.LinkBroken,
+		},
+		{
+			URL:             db.URL{URL: "http://127.0.0.1:0/unreachable.gif"},
+			ExpectedStatus:  0,
+			ExpectedOutcome: db.LinkInconclusive,
+		},
+	}
+
+	for _, example := range examples {
+		status, outcome := checker.Check(context.Background(), example.URL)
+		if status != example.ExpectedStatus || outcome != example.ExpectedOutcome {
+			t.Errorf("Expected:\n%v %v\nGot:\n%v %v\n", example.ExpectedStatus, example.ExpectedOutcome, status, outcome)
+		}
+	}
+}
+
+func TestCheckBatchServerErrorsDontHide(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store := db.NewMemory()
+	if err := store.SaveURL(context.Background(), &db.URL{Title: "a", URL: server.URL + "/a.gif"}); err != nil {
+		t.Fatal(err)
+	}
+	checker := NewChecker(store, server.URL)
+	checker.RecheckAfter = -time.Minute
+
+	for i := 0; i < db.MaximumLinkFailures; i++ {
+		if err := checker.checkBatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actual, err := store.GetURL(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LinkFailures != 0 || actual.LinkStatus != http.StatusServiceUnavailable {
+		t.Errorf("Expected:\n%v %v\nGot:\n%v %v\n", 0, http.StatusServiceUnavailable, actual.LinkFailures, actual.LinkStatus)
+	}
+	urls, _, err := store.GetURLs(context.Background(), "", db.Filter{}, false, db.Page{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 {
+		t.Errorf("Expected the gif to still be listed\nGot:\n%v\n", urls)
+	}
+}
@@ -1,96 +1,204 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/AndrewVos/ancientcitadel/assethandler"
-	"github.com/AndrewVos/ancientcitadel/controllers"
+	"github.com/AndrewVos/ancientcitadel/config"
 	"github.com/AndrewVos/ancientcitadel/db"
+	"github.com/AndrewVos/ancientcitadel/gifs"
 	"github.com/AndrewVos/ancientcitadel/ingester"
-	"github.com/gorilla/mux"
-	"github.com/justinas/alice"
-	"github.com/nytimes/gziphandler"
+	"github.com/AndrewVos/ancientcitadel/linkchecker"
+	"github.com/AndrewVos/ancientcitadel/storage"
 )
 
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":   {"serve the site and the api", serve},
+	"ingest":  {"crawl sources and check links", ingest},
+	"worker":  {"transcode queued gifs", worker},
+	"migrate": {"migrate the database", migrate},
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	runtime.GOMAXPROCS(4)
-	err := db.Migrate()
-	if err != nil {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := command.run(os.Args[2:]); err != nil {
 		log.Fatal(err)
 	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %v <command> [flags]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %v <command> -h to see a command's flags.\n", os.Args[0])
+	os.Exit(2)
+}
 
-	middleware := alice.New(
-		loggingHandler,
-		gziphandler.GzipHandler,
-		ageVerificationHandler,
-	)
-
-	port := flag.String("port", "8080", "the port to bind to")
-	flag.Parse()
-
-	r := mux.NewRouter()
-
-	jsHandler := assethandler.JS([]string{
-		"assets/scripts/jquery.min.js",
-		"assets/scripts/remodal.min.js",
-		"assets/scripts/tweet.js",
-		"assets/scripts/navigation.js",
-		"assets/scripts/play-button.js",
-		"assets/scripts/pack.js",
-		"assets/scripts/gifs.js",
-	})
-
-	cssHandler := assethandler.CSS([]string{
-		"assets/styles/bootstrap.min.css",
-		"assets/styles/remodal.css",
-		"assets/styles/remodal-default-theme.css",
-		"assets/styles/main.css",
-		"assets/styles/play-button.css",
-	})
-	handlers := map[string]http.Handler{
-		"/compiled.js":            jsHandler,
-		"/compiled.css":           cssHandler,
-		"/assets/favicons/{icon}": http.StripPrefix("/assets/favicons/", http.FileServer(http.Dir("./assets/favicons/"))),
-	}
-
-	for path, handler := range handlers {
-		r.Handle(path, middleware.Then(handler))
-	}
-
-	urlController := controllers.NewURLController()
-	apiController := controllers.NewAPIController()
-
-	handlerFuncs := map[string]func(w http.ResponseWriter, r *http.Request){
-		"/api": apiController.Docs,
-		"/api/random/{work:nsfw|sfw}":                  apiController.Random,
-		"/api/{work:nsfw|sfw}/{order:new|top|shuffle}": apiController.Index,
-		"/api/{work:nsfw|sfw}":                         apiController.Index,
-		"/":                              urlController.Index,
-		"/{top:top}":                     urlController.Index,
-		"/{shuffle:shuffle}":             urlController.Index,
-		"/{work:nsfw}":                   urlController.Index,
-		"/{work:nsfw}/{top:top}":         urlController.Index,
-		"/{work:nsfw}/{shuffle:shuffle}": urlController.Index,
-		"/gif/{slug}":                    urlController.Show,
-		"/tweet/{id:\\d+}":               tweetHandler,
-		"/twitter/callback":              twitterCallbackHandler,
-		"/sitemap.xml.gz":                sitemapHandler,
-	}
-
-	for path, handlerFunc := range handlerFuncs {
-		r.Handle(path, middleware.ThenFunc(handlerFunc))
-	}
-
-	http.Handle("/", r)
-	fmt.Printf("Starting on port %v...\n", *port)
-
-	ingester.Ingest()
-	err = http.ListenAndServe("0.0.0.0:"+*port, nil)
-	log.Fatal(err)
+// loadConfig loads config for a command, then sets up the things every
+// command shares.
+func loadConfig(cfg *config.Config, flags *flag.FlagSet, args []string) error {
+	if err := cfg.Load(flags, args); err != nil {
+		return err
+	}
+	runtime.GOMAXPROCS(cfg.MaxProcs)
+	return nil
+}
+
+// openStore connects to the database, which the caller should Close.
+func openStore(cfg *config.Config) (*db.Postgres, error) {
+	store, err := db.NewPostgres(cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	store.QueryTimeout = time.Duration(cfg.QueryTimeout) * time.Second
+	return store, nil
+}
+
+// signalContext returns a context that's cancelled when the process is
+// asked to stop, which is how every long running command knows to shut
+// down.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func migrate(args []string) error {
+	cfg := config.Default()
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	if err := loadConfig(cfg, flags, args); err != nil {
+		return err
+	}
+	return db.Migrate(cfg.DatabaseURL)
+}
+
+func ingest(args []string) error {
+	cfg := config.Default()
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	flags.StringVar(&cfg.BaseURL, "base-url", cfg.BaseURL, "where the site is served from, for checking links to /media")
+	flags.BoolVar(&cfg.CheckLinks, "check-links", cfg.CheckLinks, "recheck the links of stored gifs")
+	if err := loadConfig(cfg, flags, args); err != nil {
+		return err
+	}
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	var wg sync.WaitGroup
+	if cfg.CheckLinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			linkchecker.NewChecker(store, cfg.BaseURL).Run(ctx)
+		}()
+	}
+	err = ingester.Ingest(ctx, store)
+	wg.Wait()
+	if err == context.Canceled {
+		log.Println("stopped ingesting")
+		return nil
+	}
+	return err
+}
+
+func worker(args []string) error {
+	cfg := config.Default()
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	flags.Var((*config.TranscoderList)(&cfg.Transcoders), "transcoders", "comma separated hosts of the remote gifs servers")
+	flags.IntVar(&cfg.LocalTranscoders, "local-transcoders", cfg.LocalTranscoders, "transcode gifs with this many local ffmpeg workers instead of the remote gifs servers")
+	flags.IntVar(&cfg.Workers, "workers", cfg.Workers, "how many jobs to transcode at once")
+	flags.StringVar(&cfg.FFmpeg, "ffmpeg", cfg.FFmpeg, "the ffmpeg binary to run, with ffprobe alongside it")
+	flags.IntVar(&cfg.VisibilityTimeout, "visibility-timeout", cfg.VisibilityTimeout, "seconds a job can go without being extended before it's handed out again")
+	flags.StringVar(&cfg.MediaDirectory, "media-directory", cfg.MediaDirectory, "where media is kept when S3_BUCKET isn't set")
+	flags.BoolVar(&cfg.PersistMedia, "persist-media", cfg.PersistMedia, "keep copies of all media instead of hotlinking it")
+	if err := loadConfig(cfg, flags, args); err != nil {
+		return err
+	}
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var transcoders []gifs.Transcoder
+	for _, host := range cfg.Transcoders {
+		transcoders = append(transcoders, gifs.HTTPTranscoder{Host: host})
+	}
+
+	var persistStore, thumbnailStore storage.Store
+	if cfg.LocalTranscoders > 0 || cfg.PersistMedia {
+		store, err := mediaStore(cfg)
+		if err != nil {
+			return err
+		}
+		thumbnailStore = store
+		if cfg.LocalTranscoders > 0 {
+			transcoders = nil
+			for i := 0; i < cfg.LocalTranscoders; i++ {
+				transcoders = append(transcoders, gifs.LocalTranscoder{Store: store, FFmpeg: cfg.FFmpeg})
+			}
+		}
+		if cfg.PersistMedia {
+			persistStore = store
+		}
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	w := ingester.NewWorker(store, transcoders, persistStore, cfg.Workers)
+	w.ThumbnailStore = thumbnailStore
+	w.FFmpeg = cfg.FFmpeg
+	w.VisibilityTimeout = time.Duration(cfg.VisibilityTimeout) * time.Second
+	w.Run(ctx)
+	log.Println("stopped working")
+	return nil
+}
+
+// mediaStore keeps media in the configured S3 bucket, or in the media
+// directory when there isn't one. Either way it's served from the site's
+// /media unless the S3 base url says otherwise.
+func mediaStore(config *config.Config) (storage.Store, error) {
+	if config.S3Bucket == "" {
+		return storage.NewDirectory(config.MediaDirectory, config.MediaURL())
+	}
+	return storage.NewS3(
+		config.S3Endpoint,
+		config.S3Region,
+		config.S3Bucket,
+		config.S3AccessKeyID,
+		config.S3SecretAccessKey,
+		config.MediaURL(),
+	), nil
 }
@@ -0,0 +1,38 @@
+-- up
+CREATE TABLE sources(
+	created_at      TIMESTAMP NOT NULL DEFAULT now(),
+	id              SERIAL PRIMARY KEY,
+	name            TEXT NOT NULL,
+	nsfw            boolean NOT NULL DEFAULT false,
+	enabled         boolean NOT NULL DEFAULT true,
+	priority        INTEGER NOT NULL DEFAULT 0,
+	last_crawled_at TIMESTAMP
+);
+
+-- Sources are looked up by lower(name), so names can't differ by case alone.
+CREATE UNIQUE INDEX sources_name_idx ON sources (lower(name));
+
+INSERT INTO sources (name, nsfw) VALUES
+	('gifs', false),
+	('perfectloops', false),
+	('noisygifs', false),
+	('analogygifs', false),
+	('reversegif', false),
+	('aww_gifs', false),
+	('SlyGifs', false),
+	('AnimalsBeingJerks', false),
+	('shittyreactiongifs', false),
+	('CatGifs', false),
+	('Puggifs', false),
+	('SpaceGifs', false),
+	('physicsgifs', false),
+	('educationalgifs', false),
+	('shockwaveporn', false),
+	('gifsgonewild', true),
+	('porn_gifs', true),
+	('PornGifs', true),
+	('NSFW_SEXY_GIF', true),
+	('adultgifs', true),
+	('NSFW_GIF', true),
+	('nsfw_gifs', true),
+	('porngif', true);
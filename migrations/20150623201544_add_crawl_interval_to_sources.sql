@@ -0,0 +1,2 @@
+-- up
+ALTER TABLE sources ADD COLUMN crawl_interval INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- up
+ALTER TABLE sources ADD COLUMN sort TEXT NOT NULL DEFAULT 'hot';
+ALTER TABLE sources ADD COLUMN time_window TEXT NOT NULL DEFAULT '';
+ALTER TABLE sources ADD COLUMN backfilled_at TIMESTAMP;
+UPDATE sources SET backfilled_at = now();
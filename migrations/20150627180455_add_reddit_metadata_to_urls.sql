@@ -0,0 +1,16 @@
+-- up
+ALTER TABLE urls ADD COLUMN reddit_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN subreddit TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN author TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN score INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN num_comments INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN flair TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN spoiler boolean NOT NULL DEFAULT false;
+
+UPDATE urls SET subreddit = substring(source_url from '/r/([^/]+)/')
+	WHERE source_url LIKE '%/r/%';
+UPDATE urls SET reddit_id = substring(source_url from '/comments/([^/]+)/')
+	WHERE source_url LIKE '%/comments/%';
+
+CREATE INDEX urls_subreddit_idx ON urls (lower(subreddit));
+CREATE INDEX urls_author_idx ON urls (lower(author));
@@ -0,0 +1,5 @@
+-- up
+ALTER TABLE urls ADD COLUMN link_status INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN link_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN link_checked_at TIMESTAMP;
+CREATE INDEX urls_link_checked_at_idx ON urls (link_checked_at NULLS FIRST);
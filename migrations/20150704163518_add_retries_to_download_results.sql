@@ -0,0 +1,15 @@
+-- up
+ALTER TABLE download_results ADD COLUMN error TEXT NOT NULL DEFAULT '';
+ALTER TABLE download_results ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE download_results ADD COLUMN permanent boolean NOT NULL DEFAULT false;
+ALTER TABLE download_results ADD COLUMN retry_at TIMESTAMP;
+ALTER TABLE download_results ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT now();
+
+DELETE FROM download_results a USING download_results b
+	WHERE a.url = b.url
+	AND (a.created_at < b.created_at OR (a.created_at = b.created_at AND a.ctid < b.ctid));
+
+UPDATE download_results SET updated_at = created_at;
+
+CREATE UNIQUE INDEX download_results_url_idx ON download_results (url);
+CREATE INDEX download_results_failed_idx ON download_results (updated_at) WHERE success = false;
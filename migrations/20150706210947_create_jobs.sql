@@ -0,0 +1,17 @@
+-- up
+CREATE TABLE jobs(
+	created_at   TIMESTAMP NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMP NOT NULL DEFAULT now(),
+	id           SERIAL PRIMARY KEY,
+	url          TEXT NOT NULL,
+	payload      JSONB NOT NULL,
+	status       TEXT NOT NULL DEFAULT 'queued',
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	run_at       TIMESTAMP NOT NULL DEFAULT now(),
+	locked_until TIMESTAMP,
+	last_error   TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX jobs_pending_url_idx ON jobs (url) WHERE status IN ('queued', 'running');
+CREATE INDEX jobs_queued_idx ON jobs (run_at) WHERE status = 'queued';
+CREATE INDEX jobs_running_idx ON jobs (locked_until) WHERE status = 'running';
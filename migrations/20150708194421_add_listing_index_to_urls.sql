@@ -0,0 +1,2 @@
+-- up
+CREATE INDEX urls_listing_idx ON urls (nsfw, created_at DESC, id DESC);
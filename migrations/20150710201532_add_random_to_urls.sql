@@ -0,0 +1,3 @@
+-- up
+ALTER TABLE urls ADD COLUMN random DOUBLE PRECISION NOT NULL DEFAULT random();
+CREATE INDEX urls_shuffle_idx ON urls (nsfw, random, id);
@@ -0,0 +1,25 @@
+-- up
+ALTER TABLE urls ADD COLUMN views INTEGER NOT NULL DEFAULT 0;
+UPDATE urls SET views = counts.views
+	FROM (SELECT url_id, COUNT(*) AS views FROM url_views GROUP BY url_id) AS counts
+	WHERE counts.url_id = urls.id;
+CREATE INDEX urls_top_idx ON urls (nsfw, views DESC, id DESC);
+
+CREATE TABLE url_view_counts(
+	url_id INTEGER NOT NULL,
+	period TEXT NOT NULL,
+	bucket TIMESTAMP NOT NULL,
+	views  INTEGER NOT NULL,
+	PRIMARY KEY (period, bucket, url_id)
+);
+
+INSERT INTO url_view_counts (url_id, period, bucket, views)
+	SELECT url_id, 'hour', date_trunc('hour', created_at::timestamptz AT TIME ZONE 'UTC'), COUNT(*)
+		FROM url_views
+		WHERE created_at > now() - interval '2 days'
+		GROUP BY 1, 3;
+INSERT INTO url_view_counts (url_id, period, bucket, views)
+	SELECT url_id, 'day', date_trunc('day', created_at::timestamptz AT TIME ZONE 'UTC'), COUNT(*)
+		FROM url_views
+		WHERE created_at > now() - interval '31 days'
+		GROUP BY 1, 3;
@@ -0,0 +1,2 @@
+-- up
+ALTER TABLE download_results ADD COLUMN payload JSONB;
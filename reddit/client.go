@@ -0,0 +1,186 @@
+package reddit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultClient is used by a SubReddit that doesn't have a Client.
+var DefaultClient = NewClient(
+	&http.Client{Timeout: 30 * time.Second},
+	"https://api.reddit.com",
+	"web:com.ancientcitadel:v1 (by /u/AndrewVos)",
+	2*time.Second,
+)
+
+// Client talks to the reddit api, spacing requests out by at least
+// RateLimit and waiting for the window to reset whenever reddit's
+// X-Ratelimit-* headers say we have run out of requests.
+type Client struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	UserAgent  string
+	RateLimit  time.Duration
+
+	mutex       sync.Mutex
+	lastRequest time.Time
+	remaining   float64
+	reset       time.Time
+	sleep       func(context.Context, time.Duration) error
+}
+
+func NewClient(httpClient *http.Client, baseURL string, userAgent string, rateLimit time.Duration) *Client {
+	return &Client{
+		HTTPClient: httpClient,
+		BaseURL:    baseURL,
+		UserAgent:  userAgent,
+		RateLimit:  rateLimit,
+		remaining:  -1,
+		sleep:      sleep,
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RateLimitError is returned when reddit responds with 429 Too Many Requests.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("reddit rate limit exceeded, retry after %v", e.RetryAfter)
+}
+
+// ForbiddenError is returned for subreddits that are private or quarantined.
+type ForbiddenError struct {
+	Path string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("reddit forbids access to %v", e.Path)
+}
+
+// NotFoundError is returned for subreddits that are banned or don't exist.
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("reddit couldn't find %v", e.Path)
+}
+
+// StatusError is returned for any other unsuccessful response.
+type StatusError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("reddit responded to %v with http status %d", e.Path, e.StatusCode)
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", c.UserAgent)
+
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	c.updateRateLimit(response)
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		return &RateLimitError{RetryAfter: c.retryAfter(response)}
+	case http.StatusForbidden:
+		return &ForbiddenError{Path: path}
+	case http.StatusNotFound:
+		return &NotFoundError{Path: path}
+	default:
+		return &StatusError{Path: path, StatusCode: response.StatusCode}
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// wait takes the next free slot for a request and waits for it, or until
+// ctx is done. The slot is taken before waiting so that the mutex isn't
+// held while sleeping, and other requests queue up behind this one.
+func (c *Client) wait(ctx context.Context) error {
+	c.mutex.Lock()
+	now := time.Now()
+	next := c.lastRequest.Add(c.RateLimit)
+	if c.remaining >= 0 && c.remaining < 1 && c.reset.After(next) {
+		next = c.reset
+	}
+	if next.Before(now) {
+		next = now
+	}
+	c.lastRequest = next
+	c.mutex.Unlock()
+
+	if next.After(now) {
+		return c.sleep(ctx, next.Sub(now))
+	}
+	return ctx.Err()
+}
+
+func (c *Client) updateRateLimit(response *http.Response) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if remaining, err := strconv.ParseFloat(response.Header.Get("X-Ratelimit-Remaining"), 64); err == nil {
+		c.remaining = remaining
+	}
+	if reset, err := strconv.Atoi(response.Header.Get("X-Ratelimit-Reset")); err == nil {
+		c.reset = time.Now().Add(time.Duration(reset) * time.Second)
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		c.remaining = 0
+		if retryAfter := c.retryAfter(response); time.Now().Add(retryAfter).After(c.reset) {
+			c.reset = time.Now().Add(retryAfter)
+		}
+	}
+}
+
+func (c *Client) retryAfter(response *http.Response) time.Duration {
+	for _, header := range []string{"Retry-After", "X-Ratelimit-Reset"} {
+		if seconds, err := strconv.Atoi(response.Header.Get(header)); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return c.RateLimit
+}
@@ -0,0 +1,193 @@
+package reddit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const page = `{"data": {"after": "t3_next", "children": [
+	{"data": {"id": "a", "subreddit": "gifs", "author": "someone", "permalink": "/r/gifs/comments/a/first/", "title": "first", "url": "http://i.imgur.com/a.gif", "created_utc": 1434326400, "over_18": false, "score": 1234, "num_comments": 56, "link_flair_text": "OC", "spoiler": true}},
+	{"data": {"permalink": "/r/gifs/comments/b/second/", "title": "second", "url": "http://i.imgur.com/b.gif", "created_utc": 1434326500, "over_18": true}}
+]}}`
+
+func testClient(server *httptest.Server) (*Client, *[]time.Duration) {
+	var slept []time.Duration
+	client := NewClient(server.Client(), server.URL, "ancientcitadel-test", 0)
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return ctx.Err()
+	}
+	return client, &slept
+}
+
+func TestNextPage(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	client, _ := testClient(server)
+	subReddit := SubReddit{Name: "gifs", Client: client}
+
+	urls, err := subReddit.NextPage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RedditURL{
+		{
+			ID: "a", SubReddit: "gifs", Author: "someone",
+			Title: "first", URL: "http://i.imgur.com/a.gif", Permalink: "/r/gifs/comments/a/first/", CreatedUTC: 1434326400,
+			Score: 1234, NumComments: 56, LinkFlairText: "OC", Spoiler: true,
+		},
+		{Title: "second", URL: "http://i.imgur.com/b.gif", Permalink: "/r/gifs/comments/b/second/", CreatedUTC: 1434326500, Over18: true},
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, urls)
+	}
+
+	_, err = subReddit.NextPage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type requestExample struct {
+		Expected string
+		Actual   string
+	}
+	examples := []requestExample{
+		{Expected: "/r/gifs/hot.json", Actual: requests[0].URL.String()},
+		{Expected: "/r/gifs/hot.json?after=t3_next", Actual: requests[1].URL.String()},
+		{Expected: "ancientcitadel-test", Actual: requests[0].Header.Get("User-Agent")},
+	}
+	for _, example := range examples {
+		if example.Actual != example.Expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.Expected, example.Actual)
+		}
+	}
+}
+
+func TestNextPageSorts(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.String())
+		w.Write([]byte(`{"data": {"children": []}}`))
+	}))
+	defer server.Close()
+	client, _ := testClient(server)
+
+	type sortExample struct {
+		Sort       string
+		TimeWindow string
+		Expected   string
+	}
+	examples := []sortExample{
+		{Sort: "", Expected: "/r/gifs/hot.json"},
+		{Sort: New, Expected: "/r/gifs/new.json"},
+		{Sort: Rising, TimeWindow: "week", Expected: "/r/gifs/rising.json"},
+		{Sort: Top, TimeWindow: "all", Expected: "/r/gifs/top.json?t=all"},
+		{Sort: Controversial, TimeWindow: "day", Expected: "/r/gifs/controversial.json?t=day"},
+	}
+
+	for _, example := range examples {
+		requested = nil
+		subReddit := SubReddit{Name: "gifs", Sort: example.Sort, TimeWindow: example.TimeWindow, Client: client}
+		if _, err := subReddit.NextPage(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(requested) != 1 || requested[0] != example.Expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.Expected, requested)
+		}
+	}
+
+	for _, subReddit := range []SubReddit{
+		{Name: "gifs", Sort: "best", Client: client},
+		{Name: "gifs", Sort: Top, TimeWindow: "decade", Client: client},
+	} {
+		if _, err := subReddit.NextPage(context.Background()); err == nil {
+			t.Errorf("Expected an error for sort %q and time window %q", subReddit.Sort, subReddit.TimeWindow)
+		}
+	}
+}
+
+func TestNextPageErrors(t *testing.T) {
+	type errorExample struct {
+		StatusCode int
+		Expected   error
+	}
+	examples := []errorExample{
+		{StatusCode: http.StatusTooManyRequests, Expected: &RateLimitError{RetryAfter: 7 * time.Second}},
+		{StatusCode: http.StatusForbidden, Expected: &ForbiddenError{Path: "/r/gifs/hot.json"}},
+		{StatusCode: http.StatusNotFound, Expected: &NotFoundError{Path: "/r/gifs/hot.json"}},
+		{StatusCode: http.StatusBadGateway, Expected: &StatusError{Path: "/r/gifs/hot.json", StatusCode: http.StatusBadGateway}},
+	}
+
+	for _, example := range examples {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(example.StatusCode)
+		}))
+		client, _ := testClient(server)
+		subReddit := SubReddit{Name: "gifs", Client: client}
+
+		_, err := subReddit.NextPage(context.Background())
+		if !reflect.DeepEqual(err, example.Expected) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.Expected, err)
+		}
+		server.Close()
+	}
+}
+
+func TestClientHonoursRateLimitHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Remaining", "0.0")
+		w.Header().Set("X-Ratelimit-Reset", "30")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	client, slept := testClient(server)
+	subReddit := SubReddit{Name: "gifs", Client: client}
+
+	for i := 0; i < 2; i++ {
+		if _, err := subReddit.NextPage(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*slept) != 1 {
+		t.Fatalf("Expected:\n%v\nGot:\n%v\n", 1, len(*slept))
+	}
+	if wait := (*slept)[0]; wait < 29*time.Second || wait > 30*time.Second {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", 30*time.Second, wait)
+	}
+}
+
+func TestClientStopsWaitingWhenCancelled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client(), server.URL, "ancientcitadel-test", time.Hour)
+	subReddit := SubReddit{Name: "gifs", Client: client}
+	if _, err := subReddit.NextPage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := subReddit.NextPage(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", context.DeadlineExceeded, err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", 1, requests)
+	}
+}
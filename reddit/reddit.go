@@ -1,15 +1,53 @@
 package reddit
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 	"sync"
 )
 
+const (
+	Hot           = "hot"
+	New           = "new"
+	Top           = "top"
+	Rising        = "rising"
+	Controversial = "controversial"
+)
+
+var sorts = []string{Hot, New, Top, Rising, Controversial}
+
+var timeWindows = []string{"hour", "day", "week", "month", "year", "all"}
+
+// ValidSort reports whether sort is a listing reddit knows about.
+// The empty string is valid and means Hot.
+func ValidSort(sort string) bool {
+	return sort == "" || contains(sorts, sort)
+}
+
+// ValidTimeWindow reports whether window can be used with the top and
+// controversial listings. The empty string is valid and means reddit's default.
+func ValidTimeWindow(window string) bool {
+	return window == "" || contains(timeWindows, window)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
 type SubReddit struct {
-	Name           string
+	Name string
+	// Sort is the listing to page through, defaulting to Hot.
+	Sort string
+	// TimeWindow limits the Top and Controversial listings to posts
+	// from the last hour, day, week, month, year or all time.
+	TimeWindow     string
+	Client         *Client
 	after          string
 	finishedPaging bool
 }
@@ -19,15 +57,20 @@ type PageResult struct {
 	Error      error
 }
 
-func (sr *SubReddit) AllPages() chan PageResult {
+func (sr *SubReddit) AllPages(ctx context.Context) chan PageResult {
 	c := make(chan PageResult)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer close(c)
 		for {
-			page, err := sr.NextPage()
-			c <- PageResult{page, err}
+			page, err := sr.NextPage(ctx)
+			select {
+			case c <- PageResult{page, err}:
+			case <-ctx.Done():
+				return
+			}
 			if err != nil {
 				break
 			}
@@ -35,31 +78,41 @@ func (sr *SubReddit) AllPages() chan PageResult {
 				break
 			}
 		}
-		close(c)
 	}()
 	return c
 }
 
-func (sr *SubReddit) NextPage() ([]RedditURL, error) {
+func (sr *SubReddit) NextPage(ctx context.Context) ([]RedditURL, error) {
 	if sr.finishedPaging {
 		return nil, nil
 	}
 
-	url := fmt.Sprintf("https://api.reddit.com/r/%v/hot.json", sr.Name)
-	if sr.after != "" {
-		url += "?after=" + sr.after
+	client := sr.Client
+	if client == nil {
+		client = DefaultClient
 	}
 
-	response, err := http.Get(url)
-	if err != nil {
-		return nil, err
+	sort := sr.Sort
+	if sort == "" {
+		sort = Hot
 	}
-	b, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
+	if !ValidSort(sort) {
+		return nil, fmt.Errorf("unknown reddit sort %q", sort)
 	}
+	if !ValidTimeWindow(sr.TimeWindow) {
+		return nil, fmt.Errorf("unknown reddit time window %q", sr.TimeWindow)
+	}
+
+	query := url.Values{}
+	if sr.after != "" {
+		query.Set("after", sr.after)
+	}
+	if sr.TimeWindow != "" && (sort == Top || sort == Controversial) {
+		query.Set("t", sr.TimeWindow)
+	}
+
 	var redditResponse redditResponse
-	err = json.Unmarshal(b, &redditResponse)
+	err := client.get(ctx, fmt.Sprintf("/r/%v/%v.json", sr.Name, sort), query, &redditResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -67,11 +120,18 @@ func (sr *SubReddit) NextPage() ([]RedditURL, error) {
 	var urls []RedditURL
 	for _, child := range redditResponse.Data.Children {
 		urls = append(urls, RedditURL{
-			Title:      child.Data.Title,
-			URL:        child.Data.URL,
-			Permalink:  child.Data.Permalink,
-			CreatedUTC: child.Data.CreatedUTC,
-			Over18:     child.Data.Over18,
+			ID:            child.Data.ID,
+			SubReddit:     child.Data.SubReddit,
+			Author:        child.Data.Author,
+			Title:         child.Data.Title,
+			URL:           child.Data.URL,
+			Permalink:     child.Data.Permalink,
+			CreatedUTC:    child.Data.CreatedUTC,
+			Over18:        child.Data.Over18,
+			Score:         child.Data.Score,
+			NumComments:   child.Data.NumComments,
+			LinkFlairText: child.Data.LinkFlairText,
+			Spoiler:       child.Data.Spoiler,
 		})
 	}
 	sr.after = redditResponse.Data.After
@@ -96,17 +156,31 @@ type redditResponseChild struct {
 }
 
 type redditResponseChildData struct {
-	Permalink  string
-	Title      string
-	URL        string
-	CreatedUTC float64 `json:"created_utc"`
-	Over18     bool    `json:"over_18"`
+	ID            string
+	SubReddit     string `json:"subreddit"`
+	Author        string
+	Permalink     string
+	Title         string
+	URL           string
+	CreatedUTC    float64 `json:"created_utc"`
+	Over18        bool    `json:"over_18"`
+	Score         int
+	NumComments   int    `json:"num_comments"`
+	LinkFlairText string `json:"link_flair_text"`
+	Spoiler       bool
 }
 
 type RedditURL struct {
-	Title      string
-	URL        string
-	Permalink  string
-	CreatedUTC float64
-	Over18     bool
+	ID            string
+	SubReddit     string
+	Author        string
+	Title         string
+	URL           string
+	Permalink     string
+	CreatedUTC    float64
+	Over18        bool
+	Score         int
+	NumComments   int
+	LinkFlairText string
+	Spoiler       bool
 }
@@ -0,0 +1,185 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var alphanumeric = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// Imgur resolves gifv and video links to the mp4 imgur serves for animated
+// images, and pages and direct images to the gif imgur serves for every id.
+type Imgur struct{}
+
+func (Imgur) Handles(u *url.URL) bool {
+	return hostIs(u, "imgur.com")
+}
+
+func (Imgur) Resolve(ctx context.Context, u *url.URL) (Media, error) {
+	segments := segments(u)
+	if len(segments) == 0 {
+		return Media{}, unsupported(u, "no imgur id")
+	}
+	if segments[0] == "a" {
+		return Media{}, unsupported(u, "imgur albums aren't supported")
+	}
+
+	id, ext := splitExtension(u)
+	if stillImageExtensions[ext] {
+		return Media{}, unsupported(u, "not animated")
+	}
+	if !alphanumeric.MatchString(id) {
+		return Media{}, unsupported(u, "invalid imgur id")
+	}
+	if ext == "gifv" || ext == MP4 || ext == WEBM {
+		return Media{URL: "https://i.imgur.com/" + id + ".mp4", Type: MP4}, nil
+	}
+	return Media{URL: "https://i.imgur.com/" + id + ".gif", Type: GIF}, nil
+}
+
+var gfycatName = regexp.MustCompile(`^([A-Za-z]+)(-[\w-]+)?$`)
+
+// Gfycat resolves gfycat pages and media links to gfycat's mp4. Links
+// often have the name lowercased, but gfycat only serves media by its
+// original case, so the mp4 is looked up with gfycat's api.
+type Gfycat struct {
+	Client *http.Client
+	// APIURL is where gfycats are looked up by name.
+	APIURL string
+}
+
+func (Gfycat) Handles(u *url.URL) bool {
+	return hostIs(u, "gfycat.com")
+}
+
+func (g Gfycat) Resolve(ctx context.Context, u *url.URL) (Media, error) {
+	if len(segments(u)) == 0 {
+		return Media{}, unsupported(u, "no gfycat name")
+	}
+	name, _ := splitExtension(u)
+	match := gfycatName.FindStringSubmatch(name)
+	if match == nil {
+		return Media{}, unsupported(u, "invalid gfycat name")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", g.APIURL+match[1], nil)
+	if err != nil {
+		return Media{}, err
+	}
+	response, err := g.Client.Do(request)
+	if err != nil {
+		return Media{}, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Media{}, unsupported(u, "no such gfycat")
+	default:
+		return Media{}, fmt.Errorf("gfycat responded to %v with http status %d", match[1], response.StatusCode)
+	}
+
+	var gfycat struct {
+		GfyItem struct {
+			MP4URL string `json:"mp4Url"`
+		} `json:"gfyItem"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&gfycat); err != nil {
+		return Media{}, err
+	}
+	if gfycat.GfyItem.MP4URL == "" {
+		return Media{}, unsupported(u, "gfycat has no mp4")
+	}
+	return Media{URL: gfycat.GfyItem.MP4URL, Type: MP4}, nil
+}
+
+// redditRendition matches the video renditions v.redd.it serves, like
+// DASH_720.mp4, but not the audio.
+var redditRendition = regexp.MustCompile(`^DASH_\d+(\.mp4)?$`)
+
+// Reddit resolves media hosted by reddit itself on i.redd.it and v.redd.it.
+type Reddit struct{}
+
+func (Reddit) Handles(u *url.URL) bool {
+	return hostIs(u, "i.redd.it", "v.redd.it")
+}
+
+func (Reddit) Resolve(ctx context.Context, u *url.URL) (Media, error) {
+	segments := segments(u)
+	if len(segments) == 0 {
+		return Media{}, unsupported(u, "no reddit media id")
+	}
+
+	if hostIs(u, "v.redd.it") {
+		if !alphanumeric.MatchString(segments[0]) {
+			return Media{}, unsupported(u, "invalid reddit video id")
+		}
+		rendition := "DASH_480.mp4"
+		if len(segments) > 1 && redditRendition.MatchString(segments[1]) {
+			rendition = segments[1]
+		}
+		return Media{URL: "https://v.redd.it/" + segments[0] + "/" + rendition, Type: MP4}, nil
+	}
+
+	id, ext := splitExtension(u)
+	if ext != GIF {
+		return Media{}, unsupported(u, "not animated")
+	}
+	return Media{URL: "https://i.redd.it/" + id + ".gif", Type: GIF}, nil
+}
+
+// Giphy resolves giphy pages and media links to the original gif.
+type Giphy struct{}
+
+func (Giphy) Handles(u *url.URL) bool {
+	return hostIs(u, "giphy.com", "gph.is")
+}
+
+func (Giphy) Resolve(ctx context.Context, u *url.URL) (Media, error) {
+	if hostIs(u, "gph.is") {
+		return Media{}, unsupported(u, "giphy short links aren't supported")
+	}
+
+	segments := segments(u)
+	var id string
+	switch {
+	case len(segments) >= 2 && segments[0] == "media":
+		// media.giphy.com/media/{id}/giphy.gif
+		id = segments[1]
+	case len(segments) >= 2 && (segments[0] == "gifs" || segments[0] == "embed"):
+		// giphy.com/gifs/{title-words-}{id}
+		slug := segments[len(segments)-1]
+		id = slug[strings.LastIndex(slug, "-")+1:]
+	case len(segments) == 1 && hostIs(u, "i.giphy.com"):
+		// i.giphy.com/{id}.gif
+		id, _ = splitExtension(u)
+	}
+
+	if !alphanumeric.MatchString(id) {
+		return Media{}, unsupported(u, "no giphy id")
+	}
+	return Media{URL: "https://media.giphy.com/media/" + id + "/giphy.gif", Type: GIF}, nil
+}
+
+// Direct accepts links straight to a gif, mp4 or webm on any other host.
+type Direct struct{}
+
+func (Direct) Handles(u *url.URL) bool {
+	return true
+}
+
+func (Direct) Resolve(ctx context.Context, u *url.URL) (Media, error) {
+	_, ext := splitExtension(u)
+	switch ext {
+	case GIF, MP4, WEBM:
+		return Media{URL: u.String(), Type: ext}, nil
+	case "":
+		return Media{}, unsupported(u, "not a link to media")
+	}
+	return Media{}, unsupported(u, "unsupported file type "+ext)
+}
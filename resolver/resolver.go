@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	GIF  = "gif"
+	MP4  = "mp4"
+	WEBM = "webm"
+)
+
+// Media is the canonical location of a gif or video.
+type Media struct {
+	URL  string
+	Type string
+}
+
+// Resolver turns links on the hosts it handles into canonical media urls.
+type Resolver interface {
+	Handles(u *url.URL) bool
+	Resolve(ctx context.Context, u *url.URL) (Media, error)
+}
+
+// UnsupportedError explains why a url can't be turned into media.
+type UnsupportedError struct {
+	URL    string
+	Reason string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported url %q: %v", e.URL, e.Reason)
+}
+
+func unsupported(u *url.URL, reason string) error {
+	return &UnsupportedError{URL: u.String(), Reason: reason}
+}
+
+var resolvers = []Resolver{
+	Imgur{},
+	Gfycat{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		APIURL: "https://api.gfycat.com/v1/gfycats/",
+	},
+	Reddit{},
+	Giphy{},
+	Direct{},
+}
+
+// Register adds a resolver that takes precedence over the built in ones.
+func Register(resolver Resolver) {
+	resolvers = append([]Resolver{resolver}, resolvers...)
+}
+
+// Resolve finds the canonical media for rawURL using the first resolver
+// that handles its host.
+func Resolve(ctx context.Context, rawURL string) (Media, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return Media{}, &UnsupportedError{URL: rawURL, Reason: err.Error()}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Media{}, unsupported(u, "not an http url")
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+
+	for _, resolver := range resolvers {
+		if resolver.Handles(u) {
+			return resolver.Resolve(ctx, u)
+		}
+	}
+	return Media{}, unsupported(u, "no resolver for "+u.Host)
+}
+
+func hostIs(u *url.URL, hosts ...string) bool {
+	host := u.Host
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		host = host[:i]
+	}
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitExtension returns the last path segment of u without its
+// extension, and the lowercased extension without its dot.
+func splitExtension(u *url.URL) (string, string) {
+	base := path.Base(u.Path)
+	ext := path.Ext(base)
+	return strings.TrimSuffix(base, ext), strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+func segments(u *url.URL) []string {
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+var stillImageExtensions = map[string]bool{
+	"jpg": true, "jpeg": true, "png": true, "bmp": true, "webp": true,
+}
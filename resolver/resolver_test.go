@@ -0,0 +1,118 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeGfycat points the gfycat resolver at an api that only knows about
+// AnnualWhichGuineapig, returning a func that puts the real one back.
+func fakeGfycat() func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.ToLower(r.URL.Path) != "/annualwhichguineapig" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"gfyItem": {"gfyName": "AnnualWhichGuineapig", "mp4Url": "https://giant.gfycat.com/AnnualWhichGuineapig.mp4"}}`))
+	}))
+	original := resolvers
+	resolvers = append([]Resolver{Gfycat{Client: server.Client(), APIURL: server.URL + "/"}}, resolvers...)
+	return func() {
+		resolvers = original
+		server.Close()
+	}
+}
+
+func TestResolve(t *testing.T) {
+	defer fakeGfycat()()
+
+	type resolveExample struct {
+		URL      string
+		Expected Media
+	}
+	examples := []resolveExample{
+		{URL: "http://i.imgur.com/Zx9sVdz.gif", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.gif", Type: GIF}},
+		{URL: "http://i.imgur.com/Zx9sVdz.gifv", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.mp4", Type: MP4}},
+		{URL: "https://i.imgur.com/Zx9sVdz.mp4", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.mp4", Type: MP4}},
+		{URL: "http://i.imgur.com/Zx9sVdz.webm", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.mp4", Type: MP4}},
+		{URL: "http://imgur.com/Zx9sVdz", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.gif", Type: GIF}},
+		{URL: "https://m.imgur.com/Zx9sVdz", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.gif", Type: GIF}},
+		{URL: "http://imgur.com/gallery/Zx9sVdz", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.gif", Type: GIF}},
+		{URL: "http://imgur.com/r/gifs/Zx9sVdz", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.gif", Type: GIF}},
+		{URL: "http://i.imgur.com/Zx9sVdz.gifv?1", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.mp4", Type: MP4}},
+		{URL: "HTTP://I.IMGUR.COM/Zx9sVdz.GIFV#t=3", Expected: Media{URL: "https://i.imgur.com/Zx9sVdz.mp4", Type: MP4}},
+		{URL: "http://gfycat.com/AnnualWhichGuineapig", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "https://www.gfycat.com/AnnualWhichGuineapig", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "https://gfycat.com/gifs/detail/AnnualWhichGuineapig", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "https://gfycat.com/annualwhichguineapig-cat-jump", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "http://giant.gfycat.com/AnnualWhichGuineapig.gif", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "http://zippy.gfycat.com/AnnualWhichGuineapig.webm", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "https://thumbs.gfycat.com/AnnualWhichGuineapig-size_restricted.gif", Expected: Media{URL: "https://giant.gfycat.com/AnnualWhichGuineapig.mp4", Type: MP4}},
+		{URL: "https://i.redd.it/x9v2k4mdb8a11.gif", Expected: Media{URL: "https://i.redd.it/x9v2k4mdb8a11.gif", Type: GIF}},
+		{URL: "https://v.redd.it/9pjm4kzq7xd11", Expected: Media{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_480.mp4", Type: MP4}},
+		{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_720.mp4", Expected: Media{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_720.mp4", Type: MP4}},
+		{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_1080", Expected: Media{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_1080", Type: MP4}},
+		{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_audio.mp4", Expected: Media{URL: "https://v.redd.it/9pjm4kzq7xd11/DASH_480.mp4", Type: MP4}},
+		{URL: "http://giphy.com/gifs/cat-funny-3o7TKSjRrfIPjeiVyM", Expected: Media{URL: "https://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Type: GIF}},
+		{URL: "https://giphy.com/gifs/3o7TKSjRrfIPjeiVyM", Expected: Media{URL: "https://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Type: GIF}},
+		{URL: "https://giphy.com/embed/3o7TKSjRrfIPjeiVyM", Expected: Media{URL: "https://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Type: GIF}},
+		{URL: "http://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Expected: Media{URL: "https://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Type: GIF}},
+		{URL: "https://media2.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.webp", Expected: Media{URL: "https://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Type: GIF}},
+		{URL: "https://i.giphy.com/3o7TKSjRrfIPjeiVyM.gif", Expected: Media{URL: "https://media.giphy.com/media/3o7TKSjRrfIPjeiVyM/giphy.gif", Type: GIF}},
+		{URL: "http://example.com/funny.gif", Expected: Media{URL: "http://example.com/funny.gif", Type: GIF}},
+		{URL: "http://example.com/funny.GIF?width=300", Expected: Media{URL: "http://example.com/funny.GIF?width=300", Type: GIF}},
+		{URL: "https://example.com/videos/funny.mp4", Expected: Media{URL: "https://example.com/videos/funny.mp4", Type: MP4}},
+		{URL: "https://example.com/videos/funny.webm#loop", Expected: Media{URL: "https://example.com/videos/funny.webm", Type: WEBM}},
+	}
+
+	for _, example := range examples {
+		actual, err := Resolve(context.Background(), example.URL)
+		if err != nil {
+			t.Errorf("Expected %q to resolve, got:\n%v\n", example.URL, err)
+			continue
+		}
+		if actual != example.Expected {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.Expected, actual)
+		}
+	}
+}
+
+func TestResolveUnsupported(t *testing.T) {
+	defer fakeGfycat()()
+
+	type unsupportedExample struct {
+		URL    string
+		Reason string
+	}
+	examples := []unsupportedExample{
+		{URL: "http://i.imgur.com/Zx9sVdz.jpg", Reason: "not animated"},
+		{URL: "http://i.imgur.com/Zx9sVdz.png", Reason: "not animated"},
+		{URL: "http://imgur.com/a/x8Kd2", Reason: "imgur albums aren't supported"},
+		{URL: "http://imgur.com/", Reason: "no imgur id"},
+		{URL: "http://gfycat.com/", Reason: "no gfycat name"},
+		{URL: "https://gfycat.com/1234", Reason: "invalid gfycat name"},
+		{URL: "https://gfycat.com/MissingWhichGuineapig", Reason: "no such gfycat"},
+		{URL: "https://i.redd.it/x9v2k4mdb8a11.jpg", Reason: "not animated"},
+		{URL: "https://gph.is/1MkMDnA", Reason: "giphy short links aren't supported"},
+		{URL: "https://giphy.com/search/cats", Reason: "no giphy id"},
+		{URL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", Reason: "not a link to media"},
+		{URL: "http://example.com/photo.jpg", Reason: "unsupported file type jpg"},
+		{URL: "ftp://example.com/funny.gif", Reason: "not an http url"},
+		{URL: "/r/gifs/comments/abc", Reason: "not an http url"},
+	}
+
+	for _, example := range examples {
+		media, err := Resolve(context.Background(), example.URL)
+		unsupported, ok := err.(*UnsupportedError)
+		if !ok {
+			t.Errorf("Expected %q to be unsupported, got:\n%v %v\n", example.URL, media, err)
+			continue
+		}
+		if unsupported.Reason != example.Reason {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", example.Reason, unsupported.Reason)
+		}
+	}
+}
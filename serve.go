@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/assethandler"
+	"github.com/AndrewVos/ancientcitadel/config"
+	"github.com/AndrewVos/ancientcitadel/controllers"
+	"github.com/AndrewVos/ancientcitadel/storage"
+	"github.com/AndrewVos/ancientcitadel/viewcount"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/nytimes/gziphandler"
+)
+
+func serve(args []string) error {
+	middleware := alice.New(
+		loggingHandler,
+		gziphandler.GzipHandler,
+		ageVerificationHandler,
+	)
+
+	cfg := config.Default()
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&cfg.Port, "port", cfg.Port, "the port to bind to")
+	flags.BoolVar(&cfg.ServeMedia, "serve-media", cfg.ServeMedia, "serve transcoded and persisted media from /media")
+	flags.StringVar(&cfg.MediaDirectory, "media-directory", cfg.MediaDirectory, "where media is kept when S3_BUCKET isn't set")
+	flags.BoolVar(&cfg.CountAPIImpressions, "count-api-impressions", cfg.CountAPIImpressions, "count every gif the api returns as a view")
+	flags.BoolVar(&cfg.TrustProxy, "trust-proxy", cfg.TrustProxy, "take visitors' addresses from X-Forwarded-For")
+	if err := loadConfig(cfg, flags, args); err != nil {
+		return err
+	}
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := controllers.ParseTemplates("views/*"); err != nil {
+		return err
+	}
+
+	r := mux.NewRouter()
+
+	jsHandler := assethandler.JS([]string{
+		"assets/scripts/jquery.min.js",
+		"assets/scripts/remodal.min.js",
+		"assets/scripts/tweet.js",
+		"assets/scripts/navigation.js",
+		"assets/scripts/play-button.js",
+		"assets/scripts/pack.js",
+		"assets/scripts/gifs.js",
+	})
+
+	cssHandler := assethandler.CSS([]string{
+		"assets/styles/bootstrap.min.css",
+		"assets/styles/remodal.css",
+		"assets/styles/remodal-default-theme.css",
+		"assets/styles/main.css",
+		"assets/styles/play-button.css",
+	})
+	handlers := map[string]http.Handler{
+		"/compiled.js":            jsHandler,
+		"/compiled.css":           cssHandler,
+		"/assets/favicons/{icon}": http.StripPrefix("/assets/favicons/", http.FileServer(http.Dir("./assets/favicons/"))),
+	}
+
+	if cfg.ServeMedia {
+		store, err := mediaStore(cfg)
+		if err != nil {
+			return err
+		}
+		handlers["/media/{file:.+}"] = http.StripPrefix("/media/", storage.Handler(store))
+	}
+
+	for path, handler := range handlers {
+		r.Handle(path, middleware.Then(handler))
+	}
+
+	siteHandlers := &siteHandlers{config: cfg, store: store}
+	views := viewcount.NewRecorder(store)
+	views.TrustProxy = cfg.TrustProxy
+	urlController := controllers.NewURLController(cfg, store, views)
+	apiController := controllers.NewAPIController(cfg, store, views)
+
+	handlerFuncs := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/api":                        apiController.Docs,
+		"/api/random/{work:nsfw|sfw}": apiController.Random,
+		"/api/{work:nsfw|sfw}/{order:new|top|shuffle}": apiController.Index,
+		"/api/{work:nsfw|sfw}":                         apiController.Index,
+		"/api/{work:nsfw|sfw}/sources":                 apiController.SubReddits,
+		"/":                                            urlController.Index,
+		"/{top:top}":                                   urlController.Index,
+		"/{shuffle:shuffle}":                           urlController.Index,
+		"/{work:nsfw}":                                 urlController.Index,
+		"/{work:nsfw}/{top:top}":                       urlController.Index,
+		"/{work:nsfw}/{shuffle:shuffle}":               urlController.Index,
+		"/sources":                                     urlController.SubReddits,
+		"/{work:nsfw}/sources":                         urlController.SubReddits,
+		"/gif/{slug}":                                  urlController.Show,
+		"/tweet/{id:\\d+}":                             siteHandlers.tweetHandler,
+		"/twitter/callback":                            siteHandlers.twitterCallbackHandler,
+		"/sitemap.xml.gz":                              siteHandlers.sitemapHandler,
+	}
+
+	// Top can be limited to a window, and trending is sorted by views that
+	// count for less as they get older.
+	topWindows := "/{window:day|week|month|all}"
+	handlerFuncs["/api/{work:nsfw|sfw}/{order:top}"+topWindows] = apiController.Index
+	handlerFuncs["/api/{work:nsfw|sfw}/{order:trending}"] = apiController.Index
+	for _, work := range []string{"", "/{work:nsfw}"} {
+		handlerFuncs[work+"/{top:top}"+topWindows] = urlController.Index
+		handlerFuncs[work+"/{trending:trending}"] = urlController.Index
+	}
+
+	for _, filter := range []string{"/source/{subreddit:\\w+}", "/author/{author:[\\w-]+}"} {
+		handlerFuncs["/api/{work:nsfw|sfw}"+filter] = apiController.Index
+		handlerFuncs["/api/{work:nsfw|sfw}"+filter+"/{order:new|top|trending|shuffle}"] = apiController.Index
+		handlerFuncs["/api/{work:nsfw|sfw}"+filter+"/{order:top}"+topWindows] = apiController.Index
+		for _, work := range []string{"", "/{work:nsfw}"} {
+			handlerFuncs[work+filter] = urlController.Index
+			handlerFuncs[work+filter+"/{top:top}"] = urlController.Index
+			handlerFuncs[work+filter+"/{top:top}"+topWindows] = urlController.Index
+			handlerFuncs[work+filter+"/{trending:trending}"] = urlController.Index
+			handlerFuncs[work+filter+"/{shuffle:shuffle}"] = urlController.Index
+		}
+	}
+
+	for path, handlerFunc := range handlerFuncs {
+		r.Handle(path, middleware.ThenFunc(handlerFunc))
+	}
+	r.Handle("/api/views/{id:\\d+}", middleware.ThenFunc(apiController.StoreView)).Methods("POST")
+
+	adminController := controllers.NewAdminController(cfg, store)
+	adminMiddleware := alice.New(
+		loggingHandler,
+		siteHandlers.adminAuthenticationHandler,
+	)
+
+	r.Handle("/admin/jobs", adminMiddleware.ThenFunc(adminController.Jobs)).Methods("GET")
+	r.Handle("/admin/downloads/failed", adminMiddleware.ThenFunc(adminController.FailedDownloads)).Methods("GET")
+	r.Handle("/admin/downloads/requeue", adminMiddleware.ThenFunc(adminController.RequeueDownload)).Methods("POST")
+	r.Handle("/admin/broken", adminMiddleware.ThenFunc(adminController.BrokenURLs)).Methods("GET")
+	r.Handle("/admin/sources", adminMiddleware.ThenFunc(adminController.Sources)).Methods("GET")
+	r.Handle("/admin/sources", adminMiddleware.ThenFunc(adminController.AddSource)).Methods("POST")
+	r.Handle("/admin/sources/{name}/enable", adminMiddleware.ThenFunc(adminController.EnableSource)).Methods("POST")
+	r.Handle("/admin/sources/{name}/disable", adminMiddleware.ThenFunc(adminController.DisableSource)).Methods("POST")
+	r.Handle("/admin/sources/{name}/sort", adminMiddleware.ThenFunc(adminController.SetSourceSort)).Methods("POST")
+	r.Handle("/admin/sources/{name}/backfill", adminMiddleware.ThenFunc(adminController.BackfillSource)).Methods("POST")
+	r.Handle("/admin/sources/{name}/purge", adminMiddleware.ThenFunc(adminController.PurgeSource)).Methods("POST")
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	// Views are written until the server has stopped, so that none from
+	// requests that finish while it's shutting down are lost.
+	viewsCtx, stopViews := context.WithCancel(context.Background())
+	viewsDone := make(chan struct{})
+	go func() {
+		views.Run(viewsCtx)
+		close(viewsDone)
+	}()
+	defer func() {
+		stopViews()
+		<-viewsDone
+	}()
+
+	server := &http.Server{Addr: "0.0.0.0:" + cfg.Port, Handler: r}
+	errs := make(chan error, 1)
+	go func() {
+		fmt.Printf("Starting on port %v...\n", cfg.Port)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// shutdownTimeout is how long in flight requests get to finish, which is
+// a little less than Heroku waits before killing a dyno.
+const shutdownTimeout = 25 * time.Second
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Handler serves the files in store, with any prefix already stripped
+// from the request path. Directories are served with http.FileServer, and
+// S3 is asked for just the range a request wants, so that browsers can
+// seek through videos with range requests.
+func Handler(store Store) http.Handler {
+	if directory, ok := store.(*Directory); ok {
+		return http.FileServer(http.Dir(directory.Path))
+	}
+	if s3, ok := store.(*S3); ok {
+		return s3Handler(s3)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		file, err := store.Get(name)
+		if os.IsNotExist(err) || err == ErrInvalidName {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Cache-Control", "public, max-age=31536000")
+		io.Copy(w, file)
+	})
+}
+
+func s3Handler(s3 *S3) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		response, err := s3.GetRange(name, r.Header.Get("Range"))
+		if os.IsNotExist(err) || err == ErrInvalidName {
+			http.NotFound(w, r)
+			return
+		}
+		if s3Err, ok := err.(*S3Error); ok && s3Err.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer response.Body.Close()
+
+		for _, header := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"} {
+			if value := response.Header.Get(header); value != "" {
+				w.Header().Set(header, value)
+			}
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = response.Header.Get("Content-Type")
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000")
+		w.WriteHeader(response.StatusCode)
+		io.Copy(w, response.Body)
+	})
+}
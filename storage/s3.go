@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores files in a bucket on Amazon S3 or anything that speaks its
+// api, like MinIO. Requests use path style urls and are signed with
+// signature version 4.
+type S3 struct {
+	// Endpoint is the scheme and host of the api, like
+	// https://s3.amazonaws.com or http://localhost:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// BaseURL is where files are served from, which can be the bucket
+	// itself, a CDN in front of it, or a Handler.
+	BaseURL string
+	Client  *http.Client
+
+	now func() time.Time
+}
+
+func NewS3(endpoint, region, bucket, accessKey, secretKey, baseURL string) *S3 {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if baseURL == "" {
+		baseURL = endpoint + "/" + bucket
+	}
+	return &S3{
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+		now:       time.Now,
+	}
+}
+
+// S3Error is returned when the api responds with an unexpected status.
+type S3Error struct {
+	Method     string
+	Name       string
+	StatusCode int
+	Body       string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3 %v %v failed with http status %d: %v", e.Method, e.Name, e.StatusCode, e.Body)
+}
+
+// Put streams r to the bucket. The payload has to be hashed before it's
+// signed and S3 needs to know its length up front, so anything other than
+// a file is copied to a temporary file first rather than into memory.
+func (s *S3) Put(name string, r io.Reader, contentType string) error {
+	file, ok := r.(*os.File)
+	if !ok {
+		tmp, err := ioutil.TempFile("", "s3-upload")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		file = tmp
+	}
+
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	body := io.NewSectionReader(file, start, size)
+
+	response, err := s.do("PUT", name, body, size, hex.EncodeToString(hash.Sum(nil)), map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(name string) (io.ReadCloser, error) {
+	response, err := s.do("GET", name, nil, 0, emptyHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// GetRange gets the part of name in byteRange, a Range header, or all of
+// it when byteRange is empty. The response is 206 for a part.
+func (s *S3) GetRange(name string, byteRange string) (*http.Response, error) {
+	headers := map[string]string{}
+	if byteRange != "" {
+		headers["Range"] = byteRange
+	}
+	return s.do("GET", name, nil, 0, emptyHash, headers)
+}
+
+func (s *S3) Delete(name string) error {
+	response, err := s.do("DELETE", name, nil, 0, emptyHash, nil)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (s *S3) URL(name string) string {
+	return s.BaseURL + "/" + name
+}
+
+var emptyHash = sha256Hex(nil)
+
+func (s *S3) do(method string, name string, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		body = http.NoBody
+	}
+	request, err := http.NewRequest(method, s.Endpoint+"/"+s.Bucket+"/"+name, body)
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = size
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	s.sign(request, payloadHash)
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: name, Err: os.ErrNotExist}
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	return nil, &S3Error{Method: method, Name: name, StatusCode: response.StatusCode, Body: string(b)}
+}
+
+// sign adds an AWS signature version 4 Authorization header to request,
+// whose body hashes to payloadHash.
+func (s *S3) sign(request *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for key := range request.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(request.Header.Get(key))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		escapePath(request.URL.Path),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent encodes everything but the characters RFC 3986 leaves
+// unreserved, as signature version 4 requires.
+func escape(s string) string {
+	var buffer bytes.Buffer
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' ||
+			b == '-' || b == '_' || b == '.' || b == '~' {
+			buffer.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buffer, "%%%02X", b)
+		}
+	}
+	return buffer.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
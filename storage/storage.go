@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Store is somewhere media files can be kept and served from.
+type Store interface {
+	Put(name string, r io.Reader, contentType string) error
+	Get(name string) (io.ReadCloser, error)
+	Delete(name string) error
+	// URL is where the file called name can be downloaded from.
+	URL(name string) string
+}
+
+var ErrInvalidName = errors.New("invalid file name")
+
+// cleanName rejects names that could escape the store, such as absolute
+// paths or paths containing "..".
+func cleanName(name string) (string, error) {
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned != name || strings.Contains(name, "\\") {
+		return "", ErrInvalidName
+	}
+	return cleaned, nil
+}
+
+// Directory stores files on the local disk underneath Path, to be served
+// from BaseURL.
+type Directory struct {
+	Path    string
+	BaseURL string
+}
+
+func NewDirectory(dir string, baseURL string) (*Directory, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Directory{Path: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (d *Directory) path(name string) (string, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.Path, filepath.FromSlash(name)), nil
+}
+
+// Put writes to a temporary file first so that a half written file is
+// never served.
+func (d *Directory) Put(name string, r io.Reader, contentType string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(p), ".upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), p)
+}
+
+func (d *Directory) Get(name string) (io.ReadCloser, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (d *Directory) Delete(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *Directory) URL(name string) string {
+	return d.BaseURL + "/" + name
+}
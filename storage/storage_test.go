@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDirectory(dir, "/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put("ab/cd.mp4", strings.NewReader("video"), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Get("ab/cd.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "video" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "video", string(b))
+	}
+
+	if url := store.URL("ab/cd.mp4"); url != "/media/ab/cd.mp4" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "/media/ab/cd.mp4", url)
+	}
+
+	err = store.Delete("ab/cd.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("ab/cd.mp4"); !os.IsNotExist(err) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "not exist", err)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	names := []string{"", "/etc/passwd", "../secret", "a/../../b", "a//b", `a\b`, "a/"}
+	for _, name := range names {
+		if _, err := cleanName(name); err != ErrInvalidName {
+			t.Errorf("Expected %q to be invalid, got:\n%v\n", name, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package viewcount
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+)
+
+var bots = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|archiver|facebookexternalhit|embedly|preview|curl|wget|python|go-http-client|java/|headless`)
+
+// IsBot reports whether userAgent looks like a crawler or a script rather
+// than somebody watching gifs.
+func IsBot(userAgent string) bool {
+	return userAgent == "" || bots.MatchString(userAgent)
+}
+
+// Recorder decides which views count towards the top listings, and writes
+// them to the Store in batches from Run, so that showing a gif never waits
+// on an INSERT.
+type Recorder struct {
+	Store db.Store
+	// Window is how long a visitor's repeat views of a gif are ignored for.
+	Window time.Duration
+	// FlushInterval is how often waiting views are written.
+	FlushInterval time.Duration
+	// BatchSize is how many views are written at once. Views are written
+	// straight away when there are this many waiting.
+	BatchSize int
+	// MaxPending is how many views can be waiting before new ones are
+	// dropped, so that a slow database can't use up all the memory.
+	MaxPending int
+	// TrustProxy tells visitors apart by X-Forwarded-For rather than by
+	// the address they connected from. It's only safe behind a proxy that
+	// sets the header, otherwise anybody can send their own.
+	TrustProxy bool
+
+	// secret is hashed with visitors so that their addresses aren't kept
+	// around, and can't be recovered from the hashes.
+	secret []byte
+
+	mutex   sync.Mutex
+	pending []db.View
+	seen    map[string]time.Time
+	full    chan struct{}
+}
+
+func NewRecorder(store db.Store) *Recorder {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &Recorder{
+		Store:         store,
+		Window:        30 * time.Minute,
+		FlushInterval: 5 * time.Second,
+		BatchSize:     500,
+		MaxPending:    10000,
+		secret:        secret,
+		seen:          map[string]time.Time{},
+		full:          make(chan struct{}, 1),
+	}
+}
+
+// Record counts a view of url by whoever made r, unless they're a bot or
+// they've already viewed url in the last Window. It returns whether the
+// view was counted.
+func (rec *Recorder) Record(r *http.Request, url db.URL) bool {
+	if IsBot(r.UserAgent()) {
+		return false
+	}
+	now := time.Now()
+	key := rec.visitor(r) + ":" + strconv.Itoa(url.ID)
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	if last, ok := rec.seen[key]; ok && now.Sub(last) < rec.Window {
+		return false
+	}
+	if len(rec.pending) >= rec.MaxPending {
+		log.Println("dropping view, too many waiting to be written")
+		return false
+	}
+	rec.seen[key] = now
+	rec.pending = append(rec.pending, db.View{URLID: url.ID, CreatedAt: now})
+	if len(rec.pending) >= rec.BatchSize {
+		select {
+		case rec.full <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// visitor identifies whoever made r by a hash of their address and user
+// agent.
+func (rec *Recorder) visitor(r *http.Request) string {
+	hash := sha256.New()
+	hash.Write(rec.secret)
+	hash.Write([]byte(remoteIP(r, rec.TrustProxy)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(r.UserAgent()))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// remoteIP is the address r came from. Behind a trusted proxy, like
+// Heroku's router, that's the end of X-Forwarded-For, after anything the
+// client sent itself.
+func remoteIP(r *http.Request, trustProxy bool) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Run writes views every FlushInterval, or as soon as a batch is full,
+// until ctx is cancelled, when it writes whatever is left.
+func (rec *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(rec.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := rec.Flush(context.Background()); err != nil {
+				log.Println(err)
+			}
+			return
+		case <-ticker.C:
+			rec.forget(time.Now())
+		case <-rec.full:
+		}
+		if err := rec.Flush(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Flush writes every view that's waiting, BatchSize at a time. Views that
+// couldn't be written are kept to try again.
+func (rec *Recorder) Flush(ctx context.Context) error {
+	for {
+		rec.mutex.Lock()
+		n := len(rec.pending)
+		if n > rec.BatchSize {
+			n = rec.BatchSize
+		}
+		batch := rec.pending[:n:n]
+		rec.pending = rec.pending[n:]
+		rec.mutex.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := rec.Store.StoreURLViews(ctx, batch); err != nil {
+			rec.mutex.Lock()
+			rec.pending = append(batch, rec.pending...)
+			rec.mutex.Unlock()
+			return err
+		}
+	}
+}
+
+// forget drops visitors whose views would count again by now.
+func (rec *Recorder) forget(now time.Time) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	for key, last := range rec.seen {
+		if now.Sub(last) >= rec.Window {
+			delete(rec.seen, key)
+		}
+	}
+}
+
+// Limiter stops any one address making more than Rate requests every Per,
+// for endpoints that anybody can hammer.
+type Limiter struct {
+	Rate int
+	Per  time.Duration
+	// TrustProxy is the same as Recorder's.
+	TrustProxy bool
+
+	mutex      sync.Mutex
+	windows    map[string]*window
+	lastForgot time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func NewLimiter(rate int, per time.Duration) *Limiter {
+	return &Limiter{Rate: rate, Per: per, windows: map[string]*window{}}
+}
+
+// Allow reports whether the address r came from is still under the limit,
+// counting r towards it.
+func (l *Limiter) Allow(r *http.Request) bool {
+	now := time.Now()
+	ip := remoteIP(r, l.TrustProxy)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastForgot) >= l.Per {
+		for ip, w := range l.windows {
+			if now.Sub(w.start) >= l.Per {
+				delete(l.windows, ip)
+			}
+		}
+		l.lastForgot = now
+	}
+
+	w, ok := l.windows[ip]
+	if !ok || now.Sub(w.start) >= l.Per {
+		w = &window{start: now}
+		l.windows[ip] = w
+	}
+	w.count++
+	return w.count <= l.Rate
+}
@@ -0,0 +1,118 @@
+package viewcount
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndrewVos/ancientcitadel/db"
+)
+
+func TestIsBot(t *testing.T) {
+	examples := []struct {
+		userAgent string
+		expected  bool
+	}{
+		{"", true},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", true},
+		{"facebookexternalhit/1.1", true},
+		{"curl/7.43.0", true},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_4) AppleWebKit/600.7.12 (KHTML, like Gecko) Version/8.0.7 Safari/600.7.12", false},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 8_4 like Mac OS X) AppleWebKit/600.1.4 (KHTML, like Gecko) Version/8.0 Mobile/12H143 Safari/600.1.4", false},
+	}
+
+	for _, example := range examples {
+		actual := IsBot(example.userAgent)
+		if actual != example.expected {
+			t.Errorf("Expected IsBot(%q) to be:\n%v\nGot:\n%v\n", example.userAgent, example.expected, actual)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	store := db.NewMemory()
+	store.SaveURL(context.Background(), &db.URL{Title: "a"})
+	store.SaveURL(context.Background(), &db.URL{Title: "b"})
+	recorder := NewRecorder(store)
+
+	examples := []struct {
+		ip        string
+		userAgent string
+		id        int
+		expected  bool
+	}{
+		{"1.1.1.1", "Safari", 1, true},
+		{"1.1.1.1", "Safari", 1, false},
+		{"1.1.1.1", "Safari", 2, true},
+		{"1.1.1.1", "Firefox", 1, true},
+		{"2.2.2.2", "Safari", 1, true},
+		{"2.2.2.2", "Googlebot", 2, false},
+	}
+
+	for _, example := range examples {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = example.ip + ":1234"
+		r.Header.Set("User-Agent", example.userAgent)
+		actual := recorder.Record(r, db.URL{ID: example.id})
+		if actual != example.expected {
+			t.Errorf("Expected a view of %v by %v %v to be counted:\n%v\nGot:\n%v\n", example.id, example.ip, example.userAgent, example.expected, actual)
+		}
+	}
+
+	recorder.BatchSize = 2
+	if err := recorder.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	views := map[string]int{}
+	for _, id := range []int{1, 2} {
+		url, err := store.GetURL(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		views[url.Title] = url.Views
+	}
+	if views["a"] != 3 || views["b"] != 1 {
+		t.Errorf("Expected a to have 3 views and b 1\nGot:\n%v\n", views)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if actual := remoteIP(r, true); actual != "10.0.0.1" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "10.0.0.1", actual)
+	}
+
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	if actual := remoteIP(r, false); actual != "10.0.0.1" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "10.0.0.1", actual)
+	}
+	if actual := remoteIP(r, true); actual != "1.2.3.4" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "1.2.3.4", actual)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(2, time.Hour)
+	examples := []struct {
+		ip       string
+		expected bool
+	}{
+		{"1.1.1.1", true},
+		{"1.1.1.1", true},
+		{"2.2.2.2", true},
+		{"1.1.1.1", false},
+		{"2.2.2.2", true},
+		{"2.2.2.2", false},
+	}
+
+	for i, example := range examples {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = example.ip + ":1234"
+		actual := limiter.Allow(r)
+		if actual != example.expected {
+			t.Errorf("Expected request %v from %v to be allowed:\n%v\nGot:\n%v\n", i, example.ip, example.expected, actual)
+		}
+	}
+}